@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/keyring"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+	"github.com/muxi-Infra/autossl-qiniuyun/server"
+)
+
+// CLI 一次性命令,与常驻服务使用同一套依赖
+type CLI struct {
+	conf        *config.Conf
+	qiniuSSL    *cron.QiniuSSL
+	sslDAO      dao.Store
+	qiniuClient *qiniu.QiniuClient
+}
+
+func NewCLI(conf *config.Conf, qiniuSSL *cron.QiniuSSL, sslDAO dao.Store, qiniuClient *qiniu.QiniuClient) *CLI {
+	return &CLI{
+		conf:        conf,
+		qiniuSSL:    qiniuSSL,
+		sslDAO:      sslDAO,
+		qiniuClient: qiniuClient,
+	}
+}
+
+type command struct {
+	usage string
+	desc  string
+	args  int
+	run   func(cli *CLI, ctx context.Context, args []string) error
+
+	standalone bool // 不需要读取配置及初始化依赖
+}
+
+var commands = map[string]command{
+	"serve":        {usage: "serve", desc: "启动常驻服务(默认)"},
+	"run-once":     {usage: "run-once", desc: "立即执行一次完整的同步后退出", run: (*CLI).runOnce},
+	"renew":        {usage: "renew <parent-domain>", desc: "强制为可注册域名重新申请证书并绑定", args: 1, run: (*CLI).renew},
+	"list":         {usage: "list", desc: "列出数据库中的证书及过期时间", run: (*CLI).list},
+	"bind":         {usage: "bind <domain> <certid>", desc: "将域名绑定到指定证书", args: 2, run: (*CLI).bind},
+	"import":       {usage: "import <cert.pem> <key.pem>", desc: "上传已有的证书并由本服务管理", args: 2, run: (*CLI).importCert},
+	"check-config": {usage: "check-config", desc: "校验配置并确认七牛云凭证可用", run: (*CLI).checkConfig},
+	"gen-key":      {usage: "gen-key <key-id>", desc: "生成用于加密私钥的主密钥", args: 1, run: (*CLI).genKey, standalone: true},
+	"rotate-keys":  {usage: "rotate-keys", desc: "使用当前主密钥重新加密数据库中的私钥", run: (*CLI).rotateKeys},
+	"migrate":      {usage: "migrate <version>", desc: "将数据库结构迁移到指定版本,用于回退程序版本前回滚", args: 1, run: (*CLI).migrate},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "用法: %s [flags] [command]\n\n命令:\n", os.Args[0])
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, name := range []string{"serve", "run-once", "renew", "list", "bind", "import", "check-config", "gen-key", "rotate-keys", "migrate"} {
+		fmt.Fprintf(w, "  %s\t%s\n", commands[name].usage, commands[name].desc)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// runCommand 执行一次性命令
+func runCommand(ctx context.Context, flags config.Flags, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok || cmd.run == nil {
+		return fmt.Errorf("未知命令 %q,使用 -h 查看帮助", args[0])
+	}
+	if len(args)-1 != cmd.args {
+		return fmt.Errorf("用法: %s", cmd.usage)
+	}
+
+	if cmd.standalone {
+		return cmd.run(nil, ctx, args[1:])
+	}
+	cli, err := InitCLI(flags)
+	if err != nil {
+		return err
+	}
+	return cmd.run(cli, ctx, args[1:])
+}
+
+func (cli *CLI) runOnce(ctx context.Context, _ []string) error {
+	if err := cli.qiniuSSL.Sync(ctx); err != nil {
+		return err
+	}
+	printResult(cli.qiniuSSL.LastResult())
+	return nil
+}
+
+func (cli *CLI) renew(ctx context.Context, args []string) error {
+	err := cli.qiniuSSL.Renew(ctx, args[0])
+	printResult(cli.qiniuSSL.LastResult())
+	return err
+}
+
+func (cli *CLI) list(_ context.Context, _ []string) error {
+	ssls, err := cli.sslDAO.ListSSLs()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tCERT ID\tNOT AFTER\tDAYS LEFT\tSANS\tBOUND DOMAINS")
+	for _, ssl := range ssls {
+		domains := make([]string, 0, len(ssl.Domains))
+		for _, domain := range ssl.Domains {
+			domains = append(domains, domain.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			ssl.DomainName,
+			ssl.CertID,
+			ssl.NotAfter.Format(time.DateTime),
+			int(time.Until(ssl.NotAfter).Hours()/24),
+			strings.Join(ssl.SANs, ","),
+			strings.Join(domains, ","),
+		)
+	}
+	return w.Flush()
+}
+
+func (cli *CLI) bind(ctx context.Context, args []string) error {
+	if err := cli.qiniuSSL.Bind(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("%s 已绑定到证书 %s\n", args[0], args[1])
+	return nil
+}
+
+func (cli *CLI) importCert(ctx context.Context, args []string) error {
+	certPEM, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	sslCredit, err := cli.qiniuSSL.Import(ctx, string(certPEM), string(keyPEM))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已导入 %s 的证书, certID=%s, SAN=%v, 过期时间=%s\n",
+		sslCredit.DomainName, sslCredit.CertID, sslCredit.SANs, sslCredit.NotAfter.Format(time.DateTime))
+	return nil
+}
+
+// checkConfig 配置能够完成依赖注入说明 DNS、调度等配置合法,再确认管理接口配置和七牛云凭证可用
+func (cli *CLI) checkConfig(ctx context.Context, _ []string) error {
+	// 一次性命令不会创建管理接口,这里按常驻服务的方式创建一次,例如配置了 addr 但没有 token 时会失败
+	srv, err := server.NewServer(cli.conf, cli.qiniuSSL)
+	if err != nil {
+		return err
+	}
+	if err := cli.qiniuSSL.CheckQiniu(ctx); err != nil {
+		return err
+	}
+	fmt.Println("配置校验通过")
+	if store := cron.StoreConfig(cli.conf); store.DSN == "" {
+		fmt.Printf("  存储: %s\n", store.Driver)
+	} else {
+		fmt.Printf("  存储: %s, DSN: %s\n", store.Driver, redact.DSN(store.DSN))
+	}
+	fmt.Printf("  默认DNS平台: %s, 按后缀划分的平台: %d 个\n", cli.conf.SSL.DNS.Platform, len(cli.conf.SSL.DNS.Zones))
+	fmt.Printf("  调度: %s\n", describeSchedule(cli.conf.SSL))
+	if srv == nil {
+		fmt.Println("  管理接口: 未启用")
+	} else {
+		fmt.Printf("  管理接口: %s\n", cli.conf.Server.Addr)
+	}
+	return nil
+}
+
+func describeSchedule(conf config.SSLConf) string {
+	if conf.Cron != "" {
+		return "cron " + conf.Cron
+	}
+	if conf.Duration > 0 {
+		return "每 " + conf.Duration.String()
+	}
+	return "每 " + cron.DefaultDuration.String()
+}
+
+func (cli *CLI) genKey(_ context.Context, args []string) error {
+	key, err := keyring.Generate(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func (cli *CLI) rotateKeys(_ context.Context, _ []string) error {
+	n, err := cli.qiniuSSL.RotateKeys()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已重新加密 %d 个私钥\n", n)
+	return nil
+}
+
+func (cli *CLI) migrate(_ context.Context, args []string) error {
+	migrator, ok := cli.sslDAO.(dao.Migrator)
+	if !ok {
+		return fmt.Errorf("当前存储不支持迁移")
+	}
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("版本必须是整数:%w", err)
+	}
+	if err := migrator.MigrateTo(version); err != nil {
+		return err
+	}
+	current, err := migrator.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("当前数据库结构版本: %d(程序支持的最新版本: %d)\n", current, dao.LatestSchemaVersion())
+	return nil
+}
+
+func printResult(result cron.RunResult) {
+	if result.Error != "" {
+		fmt.Printf("同步失败: %s\n", result.Error)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tGROUP\tCERT ID\tRESULT")
+	for domain, res := range result.Domains {
+		status := "ok"
+		if !res.Success {
+			status = res.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", domain, res.Group, res.CertID, status)
+	}
+	for domain, reason := range result.Deferred {
+		fmt.Fprintf(w, "%s\t-\t-\t%s\n", domain, reason)
+	}
+	for domain, state := range result.Unavailable {
+		fmt.Fprintf(w, "%s\t-\t-\t%s\n", domain, state)
+	}
+	w.Flush()
+}
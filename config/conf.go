@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
 	"github.com/nacos-group/nacos-sdk-go/clients"
 	"github.com/nacos-group/nacos-sdk-go/common/constant"
 	"github.com/nacos-group/nacos-sdk-go/vo"
@@ -25,25 +26,148 @@ type EmailConf struct {
 }
 
 type QiniuConf struct {
-	AccessKey string `yaml:"accessKey"`
-	SecretKey string `yaml:"secretKey"`
+	AccessKey  string  `yaml:"accessKey"`
+	SecretKey  string  `yaml:"secretKey"`
+	RateLimit  float64 `yaml:"rateLimit"`  // 每个接口每秒的请求数,0 表示使用默认值
+	Burst      int     `yaml:"burst"`      // 每个接口允许的突发请求数
+	MaxRetries int     `yaml:"maxRetries"` // 429/5xx 时的最大重试次数,0 表示使用默认值
 }
 
 type SSLConf struct {
 	Email    string        `yaml:"email"`
-	Duration time.Duration `yaml:"duration"`
-	SSLPath  string        `yaml:"sslPath"`
-	Aliyun   struct {
+	Duration time.Duration `yaml:"duration"` // 固定执行间隔
+	Cron     string        `yaml:"cron"`     // cron 表达式,如 "0 3 * * *",配置后优先于 duration
+	Jitter   time.Duration `yaml:"jitter"`   // 每次执行时间附加的随机抖动上限
+
+	ShutdownTimeout time.Duration  `yaml:"shutdownTimeout"` // 收到退出信号后等待正在处理的域名组的最长时间
+	DryRun          bool           `yaml:"dryRun"`          // 只输出计划,不申请、上传、绑定或保存证书
+	SSLPath         string         `yaml:"sslPath"`
+	DNS             DNSConf        `yaml:"dns"`
+	PSL             PSLConf        `yaml:"psl"`
+	GC              GCConf         `yaml:"gc"`
+	History         HistoryConf    `yaml:"history"`
+	HTTPS           HTTPSConf      `yaml:"https"`
+	Encryption      EncryptionConf `yaml:"encryption"`
+	// Deprecated: 使用 dns 配置,仅在未配置 dns.platform 时作为阿里云凭证使用
+	Aliyun struct {
 		AccessKeyID     string `yaml:"accessKeyID"`
 		AccessKeySecret string `yaml:"accessKeySecret"`
 	} `yaml:"aliyun"`
-	DB string `yaml:"db"`
+	DB    string    `yaml:"db"` // SQLite 数据库文件,未配置 store 时使用
+	Store StoreConf `yaml:"store"`
+}
+
+// StoreConf 证书及同步记录的存储后端
+type StoreConf struct {
+	Driver string `yaml:"driver"` // sqlite(默认)、mysql、postgres、memory
+	DSN    string `yaml:"dsn"`    // sqlite 为数据库文件路径,为空时使用 ssl.db;mysql、postgres 为连接字符串
+}
+
+// DNSConf DNS-01 验证所使用的 DNS 平台及凭证
+type DNSConf struct {
+	Platform        string `yaml:"platform"` // aliyun、tencent、cloudflare
+	AccessKeyID     string `yaml:"accessKeyID"`
+	AccessKeySecret string `yaml:"accessKeySecret"`
+	Token           string `yaml:"token"` // cloudflare 的 API Token
+
+	Zones []DNSZoneConf `yaml:"zones"` // 按父域名或后缀指定 DNS 平台,未匹配的域名使用上面的默认平台
+}
+
+// PSLConf Public Suffix List 的更新配置,默认使用程序内置的快照
+type PSLConf struct {
+	URL             string        `yaml:"url"`             // 下载地址,为空时使用 publicsuffix.org
+	RefreshInterval time.Duration `yaml:"refreshInterval"` // 大于 0 时按照该间隔下载最新的列表
+}
+
+// GCConf 清理七牛云上已经被替换的旧证书
+type GCConf struct {
+	Enabled   bool          `yaml:"enabled"`
+	Retention time.Duration `yaml:"retention"` // 旧证书被替换后保留的时间,默认 7 天
+}
+
+// HistoryConf 数据库中同步记录的保留配置
+type HistoryConf struct {
+	Retention time.Duration `yaml:"retention"` // 同步记录保留的时间,默认 30 天,审计日志不会被清理
+}
+
+// HTTPSConf 绑定证书时的 https 选项,未配置的选项保持域名当前的设置
+type HTTPSConf struct {
+	Default HTTPSOverride `yaml:"default"` // 未匹配任何规则或规则未配置的选项使用的默认值
+	Rules   []HTTPSRule   `yaml:"rules"`   // 按顺序匹配,使用第一个匹配的规则
+}
+
+// HTTPSOverride 需要覆盖的选项,为空时不修改
+type HTTPSOverride struct {
+	ForceHttps  *bool `yaml:"forceHttps"`
+	Http2Enable *bool `yaml:"http2Enable"`
+}
+
+// HTTPSRule 某个域名或一类域名的 https 选项
+type HTTPSRule struct {
+	Pattern       string `yaml:"pattern"` // 域名或通配符,如 cdn.example.com、*.example.com
+	HTTPSOverride `yaml:",inline" mapstructure:",squash"`
+}
+
+// EncryptionConf 数据库中私钥加密使用的主密钥,环境变量优先于文件,都没有配置时不加密
+type EncryptionConf struct {
+	KeyEnv  string `yaml:"keyEnv"`  // 保存主密钥的环境变量,默认为 AUTOSSL_MASTER_KEY
+	KeyFile string `yaml:"keyFile"` // 保存主密钥的文件
+}
+
+// DNSZoneConf 某个父域名或后缀使用的 DNS 平台及凭证
+type DNSZoneConf struct {
+	Suffix          string `yaml:"suffix"` // 如 example.com,同时匹配 example.com 及其所有子域名
+	Platform        string `yaml:"platform"`
+	AccessKeyID     string `yaml:"accessKeyID"`
+	AccessKeySecret string `yaml:"accessKeySecret"`
+	Token           string `yaml:"token"`
+}
+
+// ServerConf 管理接口配置,addr 为空时不启用
+type ServerConf struct {
+	Addr  string `yaml:"addr"`  // 监听地址,如 ":8080"
+	Token string `yaml:"token"` // Bearer Token,启用时必须配置
 }
 
 type Conf struct {
-	SSL   SSLConf   `yaml:"ssl"`
-	Qiniu QiniuConf `yaml:"qiniu"`
-	Email EmailConf `yaml:"email"`
+	SSL    SSLConf    `yaml:"ssl"`
+	Qiniu  QiniuConf  `yaml:"qiniu"`
+	Email  EmailConf  `yaml:"email"`
+	Server ServerConf `yaml:"server"`
+}
+
+// Flags 命令行参数,优先于配置文件
+type Flags struct {
+	DryRun bool
+}
+
+// Load 读取配置并应用命令行参数
+func Load(flags Flags) (*Conf, error) {
+	conf, err := GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if flags.DryRun {
+		conf.SSL.DryRun = true
+	}
+	redact.Register(conf.Secrets()...)
+	return conf, nil
+}
+
+// Secrets 返回配置中的密钥,这些值不能出现在日志和通知中
+func (c *Conf) Secrets() []string {
+	secrets := []string{
+		c.Qiniu.SecretKey,
+		c.Email.Password,
+		c.Server.Token,
+		c.SSL.DNS.AccessKeySecret,
+		c.SSL.DNS.Token,
+		c.SSL.Aliyun.AccessKeySecret,
+	}
+	for _, zone := range c.SSL.DNS.Zones {
+		secrets = append(secrets, zone.AccessKeySecret, zone.Token)
+	}
+	return secrets
 }
 
 // 主入口：从 Nacos 拉取配置（一次性）
@@ -0,0 +1,68 @@
+package cron
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	bindRetryBase = 5 * time.Minute // 域名第一次绑定失败后的等待时间
+	bindRetryMax  = 24 * time.Hour  // 等待时间的上限
+)
+
+// bindRetryDelay 域名连续失败 attempts 次后等待的时间,每次失败翻倍
+func bindRetryDelay(attempts int) time.Duration {
+	delay := bindRetryBase
+	for i := 1; i < attempts && delay < bindRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, bindRetryMax)
+}
+
+// recordBindFailure 记录域名绑定失败的原因及下一次重试的时间
+func (q *QiniuSSL) recordBindFailure(domain, certID string, cause error) error {
+	failure, err := q.sslDAO.GetBindFailure(domain)
+	if err != nil {
+		return err
+	}
+	failure.Domain = domain
+	failure.CertID = certID
+	failure.Attempts++
+	failure.LastError = cause.Error()
+	failure.NextRetry = time.Now().Add(bindRetryDelay(failure.Attempts))
+	log.Printf("domain:%s 连续第 %d 次绑定失败,%s 后重试", domain, failure.Attempts, failure.NextRetry.Format(time.DateTime))
+	return q.sslDAO.SaveBindFailure(failure)
+}
+
+// applyBindBackoff 从各组待绑定的域名中移除仍在等待重试的失败域名,证书仍然需要覆盖它们
+func (q *QiniuSSL) applyBindBackoff(domainGroups map[string]*domainGroup) error {
+	failures, err := q.sslDAO.GetBindFailures()
+	if err != nil {
+		return fmt.Errorf("获取绑定失败的域名失败:%w", err)
+	}
+
+	now := time.Now()
+	waiting := make(map[string]time.Time)
+	for _, failure := range failures {
+		if failure.NextRetry.After(now) {
+			waiting[failure.Domain] = failure.NextRetry
+		}
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	for _, group := range domainGroups {
+		group.Domains = lo.Filter(group.Domains, func(domain string, _ int) bool {
+			next, ok := waiting[domain]
+			if ok {
+				group.Retrying[domain] = next
+			}
+			return !ok
+		})
+	}
+	return nil
+}
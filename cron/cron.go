@@ -1,7 +1,17 @@
 package cron
 
+import (
+	"context"
+	"time"
+)
+
 type Corn interface {
-	Start()
+	// Start 阻塞执行定时任务,ctx 取消后等待正在处理的任务结束再返回
+	Start(ctx context.Context)
+	// NextRun 返回下一次执行的时间
+	NextRun() time.Time
+	// Wait 等待通过管理接口触发的后台任务结束,最多等待 shutdownTimeout
+	Wait()
 }
 
 func NewCorn(q *QiniuSSL) (Corn, error) {
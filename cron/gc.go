@@ -0,0 +1,160 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+// DefaultGCRetention 被替换的证书默认保留的时间
+const DefaultGCRetention = 7 * 24 * time.Hour
+
+// GCReport 一次清理旧证书的结果
+type GCReport struct {
+	Deleted []RetiredCert `json:"deleted"`
+	Pending []RetiredCert `json:"pending"` // 尚未满足删除条件
+	Failed  []RetiredCert `json:"failed"`
+}
+
+// RetiredCert 被替换下来的证书
+type RetiredCert struct {
+	CertID     string    `json:"certId"`
+	DomainName string    `json:"domainName"`
+	ReplacedBy string    `json:"replacedBy"`
+	RetiredAt  time.Time `json:"retiredAt"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+func (r *GCReport) String() string {
+	var b strings.Builder
+	for _, cert := range r.Deleted {
+		fmt.Fprintf(&b, "已删除 certID:%s (%s),已被 %s 替换\n", cert.CertID, cert.DomainName, cert.ReplacedBy)
+	}
+	for _, cert := range r.Failed {
+		fmt.Fprintf(&b, "删除失败 certID:%s (%s):%s\n", cert.CertID, cert.DomainName, cert.Reason)
+	}
+	return b.String()
+}
+
+// collectGarbage 删除七牛云上由本服务上传、已经被替换的旧证书,
+// 只有超过保留期且原来绑定的域名都已经迁移到新证书后才会删除
+func (q *QiniuSSL) collectGarbage(ctx context.Context) (*GCReport, error) {
+	report := &GCReport{}
+	retired, err := q.sslDAO.GetRetiredSSLs()
+	if err != nil {
+		return nil, fmt.Errorf("获取待删除的证书失败:%w", err)
+	}
+	if len(retired) == 0 {
+		return report, nil
+	}
+
+	domainList, err := q.qiniuClient.GetDomainList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain list: %w", err)
+	}
+	onQiniu := make(map[string]struct{}, len(domainList.Domains))
+	for _, domain := range domainList.Domains {
+		onQiniu[domain.Name] = struct{}{}
+	}
+
+	for _, r := range retired {
+		cert := RetiredCert{
+			CertID:     r.CertID,
+			DomainName: r.DomainName,
+			ReplacedBy: r.ReplacedBy,
+			RetiredAt:  r.RetiredAt,
+		}
+
+		if deadline := r.RetiredAt.Add(q.gcRetention); time.Now().Before(deadline) {
+			cert.Reason = fmt.Sprintf("保留至 %s", deadline.Format(time.DateTime))
+			report.Pending = append(report.Pending, cert)
+			continue
+		}
+
+		domain, err := q.unmovedDomain(r, onQiniu)
+		if err != nil {
+			return nil, err
+		}
+		if domain != "" {
+			cert.Reason = fmt.Sprintf("域名 %s 尚未绑定到新证书", domain)
+			report.Pending = append(report.Pending, cert)
+			continue
+		}
+
+		if q.dryRun {
+			log.Printf("[plan] 删除七牛云上的旧证书 certID=%s (%s)", r.CertID, r.DomainName)
+			cert.Reason = "dry-run"
+			report.Pending = append(report.Pending, cert)
+			continue
+		}
+
+		// 七牛云上已经不存在时同样视为删除成功
+		err = q.qiniuClient.RemoveSSLCert(ctx, r.CertID)
+		if err != nil && !errors.Is(err, qiniu.ErrNotFound) {
+			cert.Reason = err.Error()
+			report.Failed = append(report.Failed, cert)
+			continue
+		}
+		if err := q.sslDAO.DeleteRetiredSSL(r.CertID); err != nil {
+			return nil, fmt.Errorf("certID:%s ,删除记录失败:%w", r.CertID, err)
+		}
+		report.Deleted = append(report.Deleted, cert)
+	}
+	return report, nil
+}
+
+// unmovedDomain 返回第一个仍然存在于七牛云、但还没有绑定到任何现有证书的域名,全部迁移完成时返回空字符串
+func (q *QiniuSSL) unmovedDomain(r dao.RetiredSSL, onQiniu map[string]struct{}) (string, error) {
+	for _, name := range r.Domains {
+		// 七牛云上已经删除的域名不需要迁移
+		if _, ok := onQiniu[name]; !ok {
+			continue
+		}
+		_, err := q.sslDAO.GetDomainByName(name)
+		switch {
+		case err == nil:
+		case errors.Is(err, dao.ErrNotFound):
+			return name, nil
+		default:
+			return "", fmt.Errorf("从数据库获取域名失败:%w", err)
+		}
+	}
+	return "", nil
+}
+
+// runGC 在同步结束后清理旧证书并发送删除报告
+func (q *QiniuSSL) runGC(ctx context.Context) *GCReport {
+	if !q.gcEnabled || ctx.Err() != nil {
+		return nil
+	}
+
+	report, err := q.collectGarbage(ctx)
+	if err != nil {
+		q.notify(ctx, fmt.Sprintf("清理旧证书失败:%s", err.Error()))
+		return nil
+	}
+	if len(report.Deleted) > 0 || len(report.Failed) > 0 {
+		q.notify(ctx, "旧证书清理报告:\n"+report.String())
+	}
+	return report
+}
+
+// discardUploaded 上传后保存数据库失败时删除刚上传的证书,避免七牛云上残留没有记录的证书。
+// 删除失败时尝试记录为被替换的证书,由之后的 gc 删除
+func (q *QiniuSSL) discardUploaded(ctx context.Context, ssl *dao.SSL) error {
+	err := q.qiniuClient.RemoveSSLCert(context.WithoutCancel(ctx), ssl.CertID)
+	if err == nil || errors.Is(err, qiniu.ErrNotFound) {
+		log.Printf("certID:%s 保存失败,已从七牛云删除刚上传的证书", ssl.CertID)
+		return nil
+	}
+	if retireErr := q.sslDAO.RetireSSL(&dao.SSL{DomainName: ssl.DomainName, CertID: ssl.CertID}, ""); retireErr != nil {
+		return fmt.Errorf("certID:%s ,删除刚上传的证书失败,需要手动删除:%w", ssl.CertID, errors.Join(err, retireErr))
+	}
+	return fmt.Errorf("certID:%s ,删除刚上传的证书失败,已交由 gc 删除:%w", ssl.CertID, err)
+}
@@ -0,0 +1,85 @@
+package cron
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
+	"github.com/samber/lo"
+)
+
+// domainGroup 同一个可注册域名下的域名,共用一张多 SAN 证书
+type domainGroup struct {
+	Name     string               // 可注册域名,同时作为证书在数据库和七牛云中的名称
+	SANs     []string             // 证书需要覆盖的全部名称
+	Domains  []string             // 需要绑定证书的域名
+	Stored   []string             // 已经绑定到有效证书的域名,每次同步只核对证书和 https 选项
+	Force    bool                 // 即使现有证书仍然有效也重新申请
+	Skipped  map[string]string    // 因运行状态暂时无法绑定的域名及其状态
+	Retrying map[string]time.Time // 绑定失败后等待重试的域名及下一次重试的时间
+}
+
+// coverName 返回能够覆盖该域名的证书名称及其可注册域名,
+// 例如 img.static.example.com 需要 *.static.example.com,可注册域名为 example.com,
+// 名称必须位于可注册域名之下,避免为 *.co.uk 这类公共后缀申请证书
+func coverName(resolver *psl.Resolver, domain string) (string, string, error) {
+	domain = strings.ToLower(domain)
+
+	//如果是以.开头的话表示是泛子域名,需要对应层级的泛域名证书
+	if strings.HasPrefix(domain, ".") {
+		parent := strings.TrimPrefix(domain, ".")
+		registrable, err := resolver.RegistrableDomain(parent)
+		if err != nil {
+			return "", "", fmt.Errorf("%s 是公共后缀,无法申请泛域名证书", parent)
+		}
+		return "*." + parent, registrable, nil
+	}
+
+	// 找到可注册域名,如 a.b.example.com.cn 的可注册域名为 example.com.cn
+	registrable, err := resolver.RegistrableDomain(domain)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 可注册域名本身无法被泛域名覆盖,只能使用同名的 SAN
+	if domain == registrable {
+		return domain, registrable, nil
+	}
+
+	// 泛域名只匹配一级,去掉第一段即为需要的泛域名层级
+	_, parent, _ := strings.Cut(domain, ".")
+	return "*." + parent, registrable, nil
+}
+
+// computeSANs 计算覆盖全部名称的最小 SAN 集合,可注册域名本身始终排在第一位作为 CommonName
+func computeSANs(registrable string, names []string) []string {
+	sans := lo.Uniq(append([]string{registrable}, names...))
+	sort.Strings(sans[1:])
+	return sans
+}
+
+// coversAll 判断证书的 SAN 是否已经包含全部需要的名称
+func coversAll(certSANs, required []string) bool {
+	return lo.Every(certSANs, required)
+}
+
+// sslSANs 返回证书记录的 SAN,旧版本没有记录 SAN 时从证书中解析
+func sslSANs(s *dao.SSL) []string {
+	if len(s.SANs) > 0 {
+		return s.SANs
+	}
+	block, _ := pem.Decode([]byte(s.CertPEM))
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert.DNSNames
+}
@@ -0,0 +1,127 @@
+package cron
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+func TestCoverName(t *testing.T) {
+	resolver := psl.NewResolver()
+	tests := []struct {
+		domain      string
+		name        string
+		registrable string
+		wantErr     bool
+	}{
+		// 可注册域名本身只能使用同名的 SAN
+		{"example.com", "example.com", "example.com", false},
+		{"Example.COM", "example.com", "example.com", false},
+		{"www.example.com", "*.example.com", "example.com", false},
+		// 泛域名只匹配一级,多级子域名需要对应层级的泛域名
+		{"img.static.example.com", "*.static.example.com", "example.com", false},
+		{"a.b.example.com.cn", "*.b.example.com.cn", "example.com.cn", false},
+		{"www.example.co.uk", "*.example.co.uk", "example.co.uk", false},
+		// 以 . 开头的泛子域名
+		{".example.com", "*.example.com", "example.com", false},
+		{".static.example.com", "*.static.example.com", "example.com", false},
+		// 公共后缀无法申请证书
+		{"co.uk", "", "", true},
+		{".co.uk", "", "", true},
+		{".com", "", "", true},
+	}
+	for _, tt := range tests {
+		name, registrable, err := coverName(resolver, tt.domain)
+		if (err != nil) != tt.wantErr || name != tt.name || registrable != tt.registrable {
+			t.Errorf("coverName(%q) = %q, %q, %v, want %q, %q, error %v",
+				tt.domain, name, registrable, err, tt.name, tt.registrable, tt.wantErr)
+		}
+	}
+}
+
+func TestComputeSANs(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"apex only", []string{"example.com"}, []string{"example.com"}},
+		{"apex added", []string{"*.example.com"}, []string{"example.com", "*.example.com"}},
+		{"deduplicated and sorted", []string{"*.static.example.com", "*.example.com", "example.com", "*.example.com"},
+			[]string{"example.com", "*.example.com", "*.static.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeSANs("example.com", tt.names); !slices.Equal(got, tt.want) {
+				t.Errorf("computeSANs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoversAll(t *testing.T) {
+	cert := []string{"example.com", "*.example.com"}
+	tests := []struct {
+		name     string
+		required []string
+		want     bool
+	}{
+		{"same", []string{"example.com", "*.example.com"}, true},
+		{"subset", []string{"example.com"}, true},
+		// 泛域名只覆盖一级,更深层级的名称需要重新申请
+		{"deeper name", []string{"example.com", "*.example.com", "*.static.example.com"}, false},
+		{"other apex", []string{"example.org"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coversAll(cert, tt.required); got != tt.want {
+				t.Errorf("coversAll(%v, %v) = %v, want %v", cert, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupDomains(t *testing.T) {
+	test := domainDetail("test.clouddn.com", "", false)
+	test.Type = qiniu.DomainTypeTest
+	fake := &fakeQiniu{domains: map[string]qiniu.DomainDetail{
+		"example.com":            domainDetail("example.com", "", false),
+		"www.example.com":        domainDetail("www.example.com", "", false),
+		"img.static.example.com": domainDetail("img.static.example.com", "", false),
+		".cdn.example.com":       domainDetail(".cdn.example.com", "", false),
+		"a.b.example.com.cn":     domainDetail("a.b.example.com.cn", "", false),
+		"co.uk":                  domainDetail("co.uk", "", false),
+		"test.clouddn.com":       test,
+	}}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	q := &QiniuSSL{
+		qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL), qiniu.WithRetryPolicy(qiniu.RetryPolicy{})),
+		psl:         psl.NewResolver(),
+	}
+	groups, err := q.groupDomains(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 测试域名和公共后缀不分组
+	if len(groups) != 2 {
+		t.Fatalf("groups = %v, want example.com and example.com.cn", groups)
+	}
+
+	com := groups["example.com"]
+	if want := []string{"example.com", "*.cdn.example.com", "*.example.com", "*.static.example.com"}; !slices.Equal(com.SANs, want) {
+		t.Errorf("example.com SANs = %v, want %v", com.SANs, want)
+	}
+	if got := slices.Sorted(slices.Values(com.Domains)); !slices.Equal(got, []string{".cdn.example.com", "example.com", "img.static.example.com", "www.example.com"}) {
+		t.Errorf("example.com domains = %v", got)
+	}
+	cn := groups["example.com.cn"]
+	if want := []string{"example.com.cn", "*.b.example.com.cn"}; !slices.Equal(cn.SANs, want) {
+		t.Errorf("example.com.cn SANs = %v, want %v", cn.SANs, want)
+	}
+}
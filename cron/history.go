@@ -0,0 +1,109 @@
+package cron
+
+import (
+	"log"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/metrics"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+)
+
+// DefaultRunRetention 同步记录默认保留的时间
+const DefaultRunRetention = 30 * 24 * time.Hour
+
+// 同步记录的类型
+const (
+	runKindSync  = "sync"
+	runKindRenew = "renew"
+)
+
+// beginRun 创建本次同步的记录,记录写入失败时仍然继续同步,只是审计日志不再关联到该记录
+func (q *QiniuSSL) beginRun(kind, target string) *runReport {
+	report := newRunReport()
+	run := &dao.SyncRun{Kind: kind, Target: target, StartedAt: report.result.StartedAt}
+	if err := q.sslDAO.SaveRun(run); err != nil {
+		log.Printf("保存同步记录失败:%v", err)
+	}
+	report.run = run
+	report.audit = func(action, domain, certID, message string) {
+		q.audit(run.ID, action, domain, certID, message)
+	}
+	return report
+}
+
+// endRun 结束本次同步,更新同步记录、最近一次的结果及监控指标
+func (q *QiniuSSL) endRun(report *runReport, err error) RunResult {
+	result := report.finish(err)
+	q.setLastResult(result)
+	metrics.ObserveRun(result.FinishedAt.Sub(result.StartedAt), err)
+
+	run := report.run
+	run.FinishedAt = result.FinishedAt
+	run.Error = result.Error
+	run.Succeeded, run.Failed = 0, 0
+	for _, res := range result.Domains {
+		if res.Success {
+			run.Succeeded++
+		} else {
+			run.Failed++
+		}
+	}
+	switch {
+	case err != nil:
+		run.Outcome = dao.RunOutcomeFailed
+	case run.Failed > 0:
+		run.Outcome = dao.RunOutcomePartial
+	default:
+		run.Outcome = dao.RunOutcomeSuccess
+	}
+	if err := q.sslDAO.SaveRun(run); err != nil {
+		log.Printf("保存同步记录失败:%v", err)
+	}
+	q.pruneRuns()
+	return result
+}
+
+// pruneRuns 删除超过保留时间的同步记录,避免每次同步新增的记录无限增长
+func (q *QiniuSSL) pruneRuns() {
+	if q.runRetention <= 0 {
+		return
+	}
+	n, err := q.sslDAO.PruneRuns(time.Now().Add(-q.runRetention))
+	if err != nil {
+		log.Printf("清理同步记录失败:%v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("已清理 %d 条超过 %s 的同步记录", n, q.runRetention)
+	}
+}
+
+// audit 写入一条审计日志,失败时只记录到标准日志,不影响同步
+func (q *QiniuSSL) audit(runID uint, action, domain, certID, message string) {
+	err := q.sslDAO.AddAuditLog(&dao.AuditLog{
+		RunID:   runID,
+		Action:  action,
+		CertID:  certID,
+		Domain:  domain,
+		Message: redact.String(message),
+	})
+	if err != nil {
+		log.Printf("写入审计日志失败:%v", err)
+	}
+}
+
+// Runs 返回最近的同步记录
+func (q *QiniuSSL) Runs(limit int) ([]dao.SyncRun, error) {
+	return q.sslDAO.GetRuns(limit)
+}
+
+// AuditLogs 按条件查询审计日志
+func (q *QiniuSSL) AuditLogs(query dao.AuditQuery) ([]dao.AuditLog, error) {
+	return q.sslDAO.GetAuditLogs(query)
+}
+
+// retiredMessage 审计日志中被删除证书的说明
+func retiredMessage(replacedBy string, retiredAt time.Time) string {
+	return "已被 certID:" + replacedBy + " 替换于 " + retiredAt.Format(time.DateTime)
+}
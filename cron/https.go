@@ -0,0 +1,80 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+// httpsPolicy 根据配置决定绑定证书时各个域名的 https 选项
+type httpsPolicy struct {
+	conf config.HTTPSConf
+}
+
+// resolve 依次使用匹配规则、默认值和域名当前的设置
+func (p httpsPolicy) resolve(domain string, current qiniu.HTTPSOptions) qiniu.HTTPSOptions {
+	opts := current
+	applyOverride(p.conf.Default, &opts)
+	for _, rule := range p.conf.Rules {
+		if matchPattern(rule.Pattern, domain) {
+			applyOverride(rule.HTTPSOverride, &opts)
+			break
+		}
+	}
+	return opts
+}
+
+func applyOverride(o config.HTTPSOverride, opts *qiniu.HTTPSOptions) {
+	if o.ForceHttps != nil {
+		opts.ForceHttps = *o.ForceHttps
+	}
+	if o.Http2Enable != nil {
+		opts.Http2Enable = *o.Http2Enable
+	}
+}
+
+// matchPattern 使用通配符匹配域名,格式错误的规则不匹配任何域名
+func matchPattern(pattern, domain string) bool {
+	ok, err := path.Match(pattern, domain)
+	return err == nil && ok
+}
+
+// bindDomain 将域名绑定到证书,策略没有覆盖的 https 选项保持域名当前的设置。
+// 每次修改都会触发 CDN 配置下发,因此证书和选项都已经符合预期时不会调用七牛云,此时 changed 为 false
+func (q *QiniuSSL) bindDomain(ctx context.Context, domain, certID string) (changed bool, err error) {
+	detail, err := q.qiniuClient.GetDomainDetail(ctx, domain)
+	if err != nil {
+		return false, fmt.Errorf("获取域名配置失败:%w", err)
+	}
+	// 以域名详情中的状态为准,列表获取之后状态可能已经变化
+	switch detail.OperatingState {
+	case qiniu.OperatingStateProcessing, qiniu.OperatingStateFrozen, qiniu.OperatingStateOfflined:
+		return false, &domainStateError{Domain: domain, State: detail.OperatingState}
+	}
+	current := qiniu.HTTPSOptions{
+		ForceHttps:  detail.HTTPS.ForceHttps,
+		Http2Enable: detail.HTTPS.Http2Enable,
+	}
+	opts := q.httpsPolicy.resolve(domain, current)
+	if detail.HTTPS.CertID == certID && opts == current {
+		return false, nil
+	}
+
+	if err := q.qiniuClient.ForceHTTPS(ctx, domain, certID, opts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// domainStateError 域名的运行状态不允许修改配置
+type domainStateError struct {
+	Domain string
+	State  string
+}
+
+func (e *domainStateError) Error() string {
+	return fmt.Sprintf("domain:%s 当前状态为 %s,无法修改配置", e.Domain, e.State)
+}
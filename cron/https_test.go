@@ -0,0 +1,271 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+// fakeQiniu 只实现同步已经绑定的域名需要的接口,并记录收到的请求
+type fakeQiniu struct {
+	mu       sync.Mutex
+	requests []string
+	domains  map[string]qiniu.DomainDetail
+}
+
+func (f *fakeQiniu) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /domain", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		var resp qiniu.GetDomainResp
+		for _, detail := range f.domains {
+			resp.Domains = append(resp.Domains, detail.Domain)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("GET /domain/{name}", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		detail, ok := f.domains[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(detail)
+	})
+	mux.HandleFunc("PUT /domain/{name}/sslize", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	})
+	mux.HandleFunc("GET /sslcert/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		var resp qiniu.GetSSLCertByIDResp
+		resp.Cert.Certid = r.PathValue("id")
+		resp.Cert.NotAfter = int(time.Now().Add(90 * 24 * time.Hour).Unix())
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	return mux
+}
+
+func (f *fakeQiniu) record(r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+}
+
+func (f *fakeQiniu) count(request string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func domainDetail(name, certID string, forceHttps bool) qiniu.DomainDetail {
+	var detail qiniu.DomainDetail
+	detail.Name = name
+	detail.Type = "normal"
+	detail.OperatingState = qiniu.OperatingStateSuccess
+	detail.HTTPS.CertID = certID
+	detail.HTTPS.ForceHttps = forceHttps
+	return detail
+}
+
+func TestSyncReconcilesStoredDomains(t *testing.T) {
+	fake := &fakeQiniu{domains: map[string]qiniu.DomainDetail{
+		// 证书和选项都符合预期
+		"a.example.com": domainDetail("a.example.com", "cert-1", true),
+		// 在七牛云控制台被改绑到其他证书
+		"b.example.com": domainDetail("b.example.com", "manual", true),
+		// 关闭了强制 https
+		"c.example.com": domainDetail("c.example.com", "cert-1", false),
+	}}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	store := dao.NewMemoryStore()
+	err := store.SaveSSL(&dao.SSL{
+		DomainName: "example.com",
+		CertID:     "cert-1",
+		NotAfter:   time.Now().Add(90 * 24 * time.Hour),
+		SANs:       []string{"example.com", "*.example.com"},
+		Domains:    []dao.Domain{{Name: "a.example.com"}, {Name: "b.example.com"}, {Name: "c.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forceHttps := true
+	q := &QiniuSSL{
+		qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL), qiniu.WithRateLimit(1000, 1000)),
+		sslDAO:      store,
+		psl:         psl.NewResolver(),
+		httpsPolicy: httpsPolicy{conf: config.HTTPSConf{Default: config.HTTPSOverride{ForceHttps: &forceHttps}}},
+	}
+
+	ctx := context.Background()
+	groups, err := q.getDomainGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := groups["example.com"]
+	plan, _, err := q.decide(ctx, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Action != ActionReuse || len(plan.Bind) != 0 {
+		t.Fatalf("plan = %+v, want reuse with nothing to bind", plan)
+	}
+	want := []string{"a.example.com", "b.example.com", "c.example.com"}
+	if got := slices.Sorted(slices.Values(plan.Reconcile)); !slices.Equal(got, want) {
+		t.Fatalf("reconcile = %v, want %v", got, want)
+	}
+
+	report := newRunReport()
+	if err := q.startStrategy(ctx, group, report); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个已绑定的域名只读取一次配置,只有不一致的域名才会修改
+	for _, name := range want {
+		if n := fake.count("GET /domain/" + name); n != 1 {
+			t.Errorf("GET /domain/%s called %d times, want 1", name, n)
+		}
+	}
+	if n := fake.count("PUT /domain/a.example.com/sslize"); n != 0 {
+		t.Errorf("a.example.com rebound %d times, want 0", n)
+	}
+	for _, name := range []string{"b.example.com", "c.example.com"} {
+		if n := fake.count("PUT /domain/" + name + "/sslize"); n != 1 {
+			t.Errorf("%s rebound %d times, want 1", name, n)
+		}
+	}
+	for _, name := range want {
+		if result := report.result.Domains[name]; !result.Success {
+			t.Errorf("%s result = %+v, want success", name, result)
+		}
+	}
+}
+
+func TestBindFailureNotRecordedOnShutdown(t *testing.T) {
+	for _, shutdown := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/sslcert/cert-1" {
+				var resp qiniu.GetSSLCertByIDResp
+				resp.Cert.NotAfter = int(time.Now().Add(90 * 24 * time.Hour).Unix())
+				_ = json.NewEncoder(w).Encode(resp)
+				return
+			}
+			// 读取域名配置时收到退出信号
+			if shutdown {
+				cancel()
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": 400, "error": "bad request"})
+		}))
+
+		store := dao.NewMemoryStore()
+		err := store.SaveSSL(&dao.SSL{
+			DomainName: "example.com",
+			CertID:     "cert-1",
+			NotAfter:   time.Now().Add(90 * 24 * time.Hour),
+			SANs:       []string{"example.com", "*.example.com"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := &QiniuSSL{
+			qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL), qiniu.WithRetryPolicy(qiniu.RetryPolicy{})),
+			sslDAO:      store,
+		}
+		group := &domainGroup{
+			Name:    "example.com",
+			SANs:    []string{"example.com", "*.example.com"},
+			Domains: []string{"a.example.com"},
+		}
+		if err := q.startStrategy(ctx, group, newRunReport()); err == nil {
+			t.Fatal("startStrategy succeeded, want bind error")
+		}
+		srv.Close()
+		cancel()
+
+		failure, err := store.GetBindFailure("a.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded := failure.ID != 0; recorded == shutdown {
+			t.Errorf("shutdown=%v: bind failure recorded=%v", shutdown, recorded)
+		}
+	}
+}
+
+func TestFrozenStoredDomainStaysSkipped(t *testing.T) {
+	frozen := domainDetail("b.example.com", "cert-1", true)
+	frozen.OperatingState = qiniu.OperatingStateFrozen
+	fake := &fakeQiniu{domains: map[string]qiniu.DomainDetail{
+		"a.example.com": domainDetail("a.example.com", "cert-1", true),
+		"b.example.com": frozen,
+	}}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	store := dao.NewMemoryStore()
+	err := store.SaveSSL(&dao.SSL{
+		DomainName: "example.com",
+		CertID:     "cert-1",
+		NotAfter:   time.Now().Add(90 * 24 * time.Hour),
+		SANs:       []string{"example.com", "*.example.com"},
+		Domains:    []dao.Domain{{Name: "a.example.com"}, {Name: "b.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &QiniuSSL{
+		qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL), qiniu.WithRateLimit(1000, 1000)),
+		sslDAO:      store,
+		psl:         psl.NewResolver(),
+	}
+
+	ctx := context.Background()
+	groups, err := q.getDomainGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := groups["example.com"]
+	if !slices.Equal(group.Stored, []string{"a.example.com"}) {
+		t.Fatalf("stored = %v, want [a.example.com]", group.Stored)
+	}
+	if state := group.Skipped["b.example.com"]; state != qiniu.OperatingStateFrozen {
+		t.Fatalf("skipped = %v, want b.example.com frozen", group.Skipped)
+	}
+
+	// 冻结的域名仍然出现在计划中,而不是从 Stored 和 Skipped 中同时消失
+	plan, _, err := q.decide(ctx, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(plan.Reconcile, []string{"a.example.com"}) {
+		t.Fatalf("reconcile = %v, want [a.example.com]", plan.Reconcile)
+	}
+	if state := plan.Skipped["b.example.com"]; state != qiniu.OperatingStateFrozen {
+		t.Fatalf("plan = %s, want b.example.com skipped", plan)
+	}
+}
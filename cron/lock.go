@@ -0,0 +1,80 @@
+package cron
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	syncLockName = "sync"          // 同步、续期、绑定和导入共用的锁
+	syncLockTTL  = 2 * time.Minute // 持有者崩溃后锁失效的时间,持有期间每 syncLockTTL/3 续期一次
+)
+
+// ErrLocked 其他进程(常驻服务或一次性命令)正在修改证书
+var ErrLocked = fmt.Errorf("%w: 其他进程正在执行", ErrBusy)
+
+// newLockOwner 生成本进程的锁持有者标识
+func newLockOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// tryLock 同时获取进程内和跨进程的锁,已有任务在执行时返回 ErrBusy 或 ErrLocked
+func (q *QiniuSSL) tryLock() (unlock func(), err error) {
+	if !q.runMu.TryLock() {
+		return nil, ErrBusy
+	}
+	release, err := q.lockStore()
+	if err != nil {
+		q.runMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		q.runMu.Unlock()
+	}, nil
+}
+
+// lockStore 通过数据库获取跨进程的锁,持有期间在后台续期
+func (q *QiniuSSL) lockStore() (release func(), err error) {
+	ok, err := q.sslDAO.AcquireLock(syncLockName, q.lockOwner, syncLockTTL)
+	if err != nil {
+		return nil, fmt.Errorf("获取跨进程锁失败:%w", err)
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(syncLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ok, err := q.sslDAO.AcquireLock(syncLockName, q.lockOwner, syncLockTTL)
+				if err != nil || !ok {
+					log.Printf("续期跨进程锁失败,其他进程可能同时修改证书:%v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		if err := q.sslDAO.ReleaseLock(syncLockName, q.lockOwner); err != nil {
+			log.Printf("释放跨进程锁失败,将在 %s 后自动失效:%v", syncLockTTL, err)
+		}
+	}, nil
+}
@@ -0,0 +1,36 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+)
+
+func TestTryLockAcrossProcesses(t *testing.T) {
+	store := dao.NewMemoryStore()
+	daemon := &QiniuSSL{sslDAO: store, lockOwner: "daemon"}
+	cli := &QiniuSSL{sslDAO: store, lockOwner: "cli"}
+
+	unlock, err := daemon.tryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Bind(context.Background(), "www.example.com", "cert-1"); !errors.Is(err, ErrLocked) || !errors.Is(err, ErrBusy) {
+		t.Fatalf("Bind err = %v, want ErrLocked", err)
+	}
+	if _, err := cli.RotateKeys(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("RotateKeys err = %v, want ErrLocked", err)
+	}
+	if _, err := daemon.tryLock(); !errors.Is(err, ErrBusy) {
+		t.Fatalf("second tryLock err = %v, want ErrBusy", err)
+	}
+
+	unlock()
+	unlock, err = cli.tryLock()
+	if err != nil {
+		t.Fatalf("tryLock after release: %v", err)
+	}
+	unlock()
+}
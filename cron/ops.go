@@ -0,0 +1,153 @@
+package cron
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+	"github.com/samber/lo"
+)
+
+// Bind 将域名绑定到指定证书并开启 HTTPS,证书由本服务管理时同时记录绑定关系
+func (q *QiniuSSL) Bind(ctx context.Context, domain, certID string) error {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sslCredit, err := q.sslDAO.GetSSLByCertID(certID)
+	switch {
+	case err == nil:
+	case errors.Is(err, dao.ErrNotFound):
+		// 不是本服务申请的证书,确认七牛云上存在即可,但不会记录绑定关系
+		if _, err := q.qiniuClient.GETSSLCertById(ctx, certID); err != nil {
+			return fmt.Errorf("certID:%s ,从七牛云获取证书失败:%w", certID, err)
+		}
+		log.Printf("certID:%s 不在数据库中,绑定后不会被自动续期", certID)
+		sslCredit = nil
+	default:
+		return fmt.Errorf("从数据库获取证书失败:%w", err)
+	}
+
+	if q.dryRun {
+		log.Printf("[plan] 将域名 %s 绑定到 certID=%s", domain, certID)
+		return nil
+	}
+
+	changed, err := q.bindDomain(ctx, domain, certID)
+	if err != nil {
+		return fmt.Errorf("domain:%s, certID:%s, 启用证书失败:%w", domain, certID, err)
+	}
+	if changed {
+		q.audit(0, dao.AuditActionBind, domain, certID, "手动绑定")
+	} else {
+		log.Printf("domain:%s 已经绑定到 certID:%s,无需修改", domain, certID)
+	}
+	if err := q.sslDAO.ClearBindFailure(domain); err != nil {
+		log.Printf("domain:%s 清除绑定失败记录失败:%v", domain, err)
+	}
+	if sslCredit == nil {
+		return nil
+	}
+
+	sslCredit.Domains = lo.UniqBy(append(sslCredit.Domains, dao.Domain{Name: domain}), func(d dao.Domain) string {
+		return d.Name
+	})
+	if err := q.sslDAO.SaveSSL(sslCredit); err != nil {
+		return fmt.Errorf("domain:%s, certID:%s, 保存或更新证书失败:%w", domain, certID, err)
+	}
+	return nil
+}
+
+// Import 上传已有的证书和私钥并替换数据库中同一可注册域名的证书,下一次同步时组内域名会绑定到该证书
+func (q *QiniuSSL) Import(ctx context.Context, certPEM, keyPEM string) (*dao.SSL, error) {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 校验证书与私钥是否匹配
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("证书与私钥不匹配:%w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	names := cert.DNSNames
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = []string{cert.Subject.CommonName}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("证书中没有任何域名")
+	}
+	name, err := q.psl.RegistrableDomain(strings.TrimPrefix(names[0], "*."))
+	if err != nil {
+		return nil, err
+	}
+	if !checkIfPass(time.Now().Unix(), cert.NotAfter.Unix()) {
+		return nil, fmt.Errorf("证书将于 %s 过期,导入后会立即被替换", cert.NotAfter.Format("2006-01-02"))
+	}
+
+	sslCredit := &dao.SSL{
+		DomainName: name,
+		CertPEM:    certPEM,
+		KeyPEM:     keyPEM,
+		NotAfter:   cert.NotAfter,
+		SANs:       names,
+	}
+	if q.dryRun {
+		log.Printf("[plan] 导入 %s 的证书, SAN=%v", name, names)
+		return sslCredit, nil
+	}
+
+	// 替换同名的证书记录,原有的域名会在下一次同步时绑定到新证书
+	old, err := q.sslDAO.GetSSLByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("从数据库获取证书失败:%w", err)
+	}
+
+	resp, err := q.qiniuClient.UPSSLCert(ctx, keyPEM, certPEM, name)
+	if err != nil {
+		return nil, fmt.Errorf("证书:%s ,Domain:%s,上传证书失败:%w", redact.Summarize(cert), name, redact.Error(err))
+	}
+	sslCredit.CertID = resp.CertID
+	q.audit(0, dao.AuditActionUpload, name, sslCredit.CertID, fmt.Sprintf("导入证书, SAN=%v", names))
+
+	if err := q.sslDAO.ReplaceSSL(old, sslCredit); err != nil {
+		err = fmt.Errorf("domain:%s, certID:%s, 保存或更新证书失败:%w", name, sslCredit.CertID, err)
+		return nil, errors.Join(err, q.discardUploaded(ctx, sslCredit))
+	}
+	return sslCredit, nil
+}
+
+// CheckQiniu 使用当前凭证请求一次七牛云,确认 accessKey 和 secretKey 可用
+func (q *QiniuSSL) CheckQiniu(ctx context.Context) error {
+	_, err := q.qiniuClient.GetDomainPage(ctx, "")
+	if errors.Is(err, qiniu.ErrAuth) {
+		return fmt.Errorf("七牛云鉴权失败,请检查 accessKey 和 secretKey:%w", err)
+	}
+	return err
+}
+
+// RotateKeys 持有与同步相同的锁重新加密私钥,避免同步时将旧主密钥加密的私钥写回数据库
+func (q *QiniuSSL) RotateKeys() (int, error) {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	return q.sslDAO.RotateKeys()
+}
@@ -0,0 +1,171 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/samber/lo"
+)
+
+// PlanAction 对一个域名组将要执行的操作
+type PlanAction string
+
+const (
+	ActionObtain   PlanAction = "obtain"   // 申请新证书
+	ActionReupload PlanAction = "reupload" // 七牛云上的证书已被删除,重新上传数据库中的证书
+	ActionReuse    PlanAction = "reuse"    // 复用现有证书
+	ActionSkip     PlanAction = "skip"     // 证书有效且没有需要绑定的域名
+)
+
+// GroupPlan 一个域名组的处理计划
+type GroupPlan struct {
+	Group     string               `json:"group"`
+	Action    PlanAction           `json:"action"`
+	CertID    string               `json:"certId,omitempty"` // 现有证书的 ID,申请新证书时为将要替换的证书
+	Reason    string               `json:"reason,omitempty"`
+	SANs      []string             `json:"sans"`
+	Bind      []string             `json:"bind"`                // 需要绑定证书的域名
+	Reconcile []string             `json:"reconcile,omitempty"` // 已经绑定的域名,只在证书或 https 选项不一致时修改
+	Skipped   map[string]string    `json:"skipped,omitempty"`   // 因运行状态暂不处理的域名
+	Retrying  map[string]time.Time `json:"retrying,omitempty"`  // 绑定失败后等待重试的域名
+}
+
+func (p GroupPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: ", p.Group)
+	switch p.Action {
+	case ActionObtain:
+		fmt.Fprintf(&b, "申请新证书(%s), SAN=%v", p.Reason, p.SANs)
+		if p.CertID != "" {
+			fmt.Fprintf(&b, ", 替换 certID=%s", p.CertID)
+		}
+	case ActionReupload:
+		fmt.Fprintf(&b, "重新上传证书(%s), certID=%s", p.Reason, p.CertID)
+	case ActionReuse:
+		fmt.Fprintf(&b, "复用证书 certID=%s", p.CertID)
+	case ActionSkip:
+		fmt.Fprintf(&b, "跳过, certID=%s", p.CertID)
+		if p.Reason != "" {
+			fmt.Fprintf(&b, "(%s)", p.Reason)
+		}
+		return b.String()
+	}
+	fmt.Fprintf(&b, ", 绑定域名=%v", p.Bind)
+	if len(p.Reconcile) > 0 {
+		fmt.Fprintf(&b, ", 核对配置=%v", p.Reconcile)
+	}
+	if len(p.Skipped) > 0 {
+		fmt.Fprintf(&b, ", 暂不处理=%v", p.Skipped)
+	}
+	if len(p.Retrying) > 0 {
+		fmt.Fprintf(&b, ", 等待重试=%v", lo.Keys(p.Retrying))
+	}
+	return b.String()
+}
+
+// Plan 计算每个域名组将要执行的操作,只读取数据库和七牛云,不产生任何修改
+func (q *QiniuSSL) Plan(ctx context.Context) ([]GroupPlan, error) {
+	domainGroups, err := q.getDomainGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]GroupPlan, 0, len(domainGroups))
+	for _, group := range domainGroups {
+		plan, _, err := q.decide(ctx, group)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", group.Name, err)
+		}
+		plans = append(plans, plan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Group < plans[j].Group
+	})
+	return plans, nil
+}
+
+// printPlan 输出 dry-run 模式下的计划
+func printPlan(plans []GroupPlan) {
+	if len(plans) == 0 {
+		log.Println("[plan] 没有需要处理的域名")
+	}
+	for _, plan := range plans {
+		log.Printf("[plan] %s", plan)
+	}
+}
+
+// decide 根据数据库和七牛云中证书的状态决定域名组的处理方式,同时返回数据库中的证书记录
+func (q *QiniuSSL) decide(ctx context.Context, group *domainGroup) (GroupPlan, *dao.SSL, error) {
+	now := time.Now()
+	plan := GroupPlan{
+		Group:    group.Name,
+		SANs:     group.SANs,
+		Bind:     group.Domains,
+		Skipped:  group.Skipped,
+		Retrying: group.Retrying,
+	}
+
+	// 组内的域名都已冻结或下线
+	if len(group.SANs) == 0 {
+		plan.Action, plan.Reason = ActionSkip, "没有可用的域名"
+		return plan, &dao.SSL{}, nil
+	}
+
+	sslCredit, err := q.sslDAO.GetSSLByName(group.Name)
+	if err != nil {
+		return GroupPlan{}, nil, fmt.Errorf("从数据库获取证书失败:%w", err)
+	}
+	plan.CertID = sslCredit.CertID
+
+	switch {
+	// 如果查询不到直接获取最新的
+	case sslCredit.ID == 0:
+		plan.Action, plan.Reason = ActionObtain, "数据库中没有证书"
+	case group.Force:
+		plan.Action, plan.Reason = ActionObtain, "强制续期"
+	case !checkIfPass(now.Unix(), sslCredit.NotAfter.Unix()):
+		plan.Action, plan.Reason = ActionObtain, "证书即将过期"
+	case !coversAll(sslSANs(sslCredit), group.SANs):
+		plan.Action, plan.Reason = ActionObtain, "证书没有覆盖组内全部名称"
+	default:
+		// 从七牛云获取证书
+		resp, err := q.qiniuClient.GETSSLCertById(ctx, sslCredit.CertID)
+		switch {
+		case err == nil:
+			plan.Action = ActionReuse
+			// 如果七牛云已经失效则重新获取
+			if !checkIfPass(now.Unix(), int64(resp.Cert.NotAfter)) {
+				plan.Action, plan.Reason = ActionObtain, "七牛云上的证书即将过期"
+			}
+		case errors.Is(err, qiniu.ErrNotFound):
+			// 七牛云上的证书已被删除,原有的域名都需要重新绑定
+			plan.Action, plan.Reason = ActionReupload, "七牛云上的证书已被删除"
+			plan.Bind = lo.Uniq(append(plan.Bind, lo.Map(sslCredit.Domains, func(d dao.Domain, _ int) string {
+				return d.Name
+			})...))
+		case errors.Is(err, qiniu.ErrAuth):
+			return GroupPlan{}, nil, fmt.Errorf("七牛云鉴权失败,请检查 accessKey 和 secretKey:%w", err)
+		default:
+			return GroupPlan{}, nil, fmt.Errorf("certID:%s ,从七牛云获取证书失败:%w", sslCredit.CertID, err)
+		}
+	}
+
+	// 证书不变时已经绑定的域名只需要核对,证书更换时需要全部重新绑定
+	if plan.Action == ActionReuse {
+		plan.Reconcile = group.Stored
+	} else {
+		plan.Bind = lo.Union(plan.Bind, group.Stored)
+	}
+	if plan.Action == ActionReuse && len(plan.Bind) == 0 && len(plan.Reconcile) == 0 {
+		plan.Action = ActionSkip
+	}
+	return plan, sslCredit, nil
+}
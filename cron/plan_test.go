@@ -0,0 +1,41 @@
+package cron
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+func TestDecideSkipsUnavailableGroup(t *testing.T) {
+	frozen := domainDetail("a.example.com", "", false)
+	frozen.OperatingState = qiniu.OperatingStateFrozen
+	offlined := domainDetail("example.com", "", false)
+	offlined.OperatingState = qiniu.OperatingStateOfflined
+	fake := &fakeQiniu{domains: map[string]qiniu.DomainDetail{
+		"a.example.com": frozen,
+		"example.com":   offlined,
+	}}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	q := &QiniuSSL{
+		qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL)),
+		sslDAO:      dao.NewMemoryStore(),
+		psl:         psl.NewResolver(),
+	}
+	plans, err := q.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("plans = %+v, want one group", plans)
+	}
+	// 全部冻结或下线的组不能申请只包含可注册域名的证书
+	if plan := plans[0]; plan.Action != ActionSkip || len(plan.SANs) != 0 {
+		t.Fatalf("plan = %+v, want skip without SANs", plan)
+	}
+}
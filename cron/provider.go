@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"log"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/email"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/keyring"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/metrics"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+// NewQiniuClient 根据配置创建七牛云客户端
+func NewQiniuClient(conf *config.Conf) *qiniu.QiniuClient {
+	retryPolicy := qiniu.DefaultRetryPolicy
+	if conf.Qiniu.MaxRetries > 0 {
+		retryPolicy.MaxRetries = conf.Qiniu.MaxRetries
+	}
+	return qiniu.NewQiniuClient(
+		conf.Qiniu.AccessKey,
+		conf.Qiniu.SecretKey,
+		qiniu.WithRateLimit(conf.Qiniu.RateLimit, conf.Qiniu.Burst),
+		qiniu.WithRetryPolicy(retryPolicy),
+		qiniu.WithObserver(metrics.ObserveQiniuRequest),
+	)
+}
+
+// NewEmailClient 根据配置创建邮件客户端
+func NewEmailClient(conf *config.Conf) *email.EmailClient {
+	return email.NewEmailClient(
+		conf.Email.UserName,
+		conf.Email.Password,
+		conf.Email.Sender,
+		conf.Email.SmtpHost,
+		conf.Email.SmtpPort,
+	)
+}
+
+// DefaultMasterKeyEnv 未配置 ssl.encryption.keyEnv 时读取主密钥的环境变量
+const DefaultMasterKeyEnv = "AUTOSSL_MASTER_KEY"
+
+// StoreConfig 返回实际使用的存储配置,未配置 driver 时使用 sqlite,sqlite 未配置 dsn 时使用 ssl.db
+func StoreConfig(conf *config.Conf) config.StoreConf {
+	store := conf.SSL.Store
+	if store.Driver == "" {
+		store.Driver = dao.DriverSQLite
+	}
+	if store.DSN == "" && store.Driver == dao.DriverSQLite {
+		store.DSN = conf.SSL.DB
+	}
+	return store
+}
+
+// NewSSLDao 根据配置打开证书存储,配置了主密钥时私钥加密保存
+func NewSSLDao(conf *config.Conf) (dao.Store, error) {
+	store := StoreConfig(conf)
+	if store.Driver == "memory" {
+		log.Println("使用内存存储,进程退出后证书记录将丢失")
+		return dao.NewMemoryStore(), nil
+	}
+
+	keyEnv := conf.SSL.Encryption.KeyEnv
+	if keyEnv == "" {
+		keyEnv = DefaultMasterKeyEnv
+	}
+	keys, err := keyring.Load(keyEnv, conf.SSL.Encryption.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		log.Printf("没有配置主密钥,私钥将以明文保存在数据库中,可以通过环境变量 %s 或 ssl.encryption.keyFile 配置", keyEnv)
+	}
+	return dao.Open(store.Driver, store.DSN, keys)
+}
@@ -0,0 +1,130 @@
+package cron
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+)
+
+// RunResult 一次同步的结果
+type RunResult struct {
+	StartedAt   time.Time               `json:"startedAt"`
+	FinishedAt  time.Time               `json:"finishedAt"`
+	Error       string                  `json:"error,omitempty"`
+	Domains     map[string]DomainResult `json:"domains"`
+	Deferred    map[string]string       `json:"deferred,omitempty"`    // 本次没有绑定、之后会重试的域名及原因
+	Unavailable map[string]string       `json:"unavailable,omitempty"` // 冻结或下线的域名及其状态
+	GC          *GCReport               `json:"gc,omitempty"`          // 未启用 gc 时为空
+}
+
+// DomainResult 单个域名在一次同步中的处理结果
+type DomainResult struct {
+	Group   string    `json:"group"` // 所属的可注册域名
+	CertID  string    `json:"certId,omitempty"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// runReport 收集一次同步过程中各个域名的结果
+type runReport struct {
+	mu     sync.Mutex
+	result RunResult
+
+	run   *dao.SyncRun                                 // 对应的同步记录
+	audit func(action, domain, certID, message string) // 写入关联到本次同步的审计日志
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		run:   &dao.SyncRun{},
+		audit: func(action, domain, certID, message string) {},
+		result: RunResult{
+			StartedAt:   time.Now(),
+			Domains:     make(map[string]DomainResult),
+			Deferred:    make(map[string]string),
+			Unavailable: make(map[string]string),
+		},
+	}
+}
+
+// success 记录域名绑定成功
+func (r *runReport) success(group, domain, certID string) {
+	r.set(domain, DomainResult{Group: group, CertID: certID, Success: true})
+}
+
+// fail 记录域名处理失败
+func (r *runReport) fail(group, domain, certID string, err error) {
+	msg := redact.String(err.Error())
+	r.audit(dao.AuditActionError, domain, certID, msg)
+	r.set(domain, DomainResult{Group: group, CertID: certID, Error: msg})
+}
+
+// skip 记录因运行状态没有处理的域名,配置下发中的域名会在下一次同步时重试
+func (r *runReport) skip(domain, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state == qiniu.OperatingStateProcessing {
+		r.result.Deferred[domain] = "配置下发中,下一次同步时重试"
+		return
+	}
+	r.result.Unavailable[domain] = state
+}
+
+// retryAt 记录绑定失败后仍在等待重试的域名
+func (r *runReport) retryAt(domain string, next time.Time) {
+	r.mu.Lock()
+	r.result.Deferred[domain] = "绑定失败,将于 " + next.Format(time.DateTime) + " 后重试"
+	r.mu.Unlock()
+}
+
+// failUnrecorded 将还没有结果的域名记为失败,用于整组处理失败的情况
+func (r *runReport) failUnrecorded(group string, domains []string, err error) {
+	msg := redact.String(err.Error())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, domain := range domains {
+		_, deferred := r.result.Deferred[domain]
+		_, unavailable := r.result.Unavailable[domain]
+		if deferred || unavailable {
+			continue
+		}
+		if _, ok := r.result.Domains[domain]; !ok {
+			r.result.Domains[domain] = DomainResult{Group: group, Error: msg, Time: time.Now()}
+			r.audit(dao.AuditActionError, domain, "", msg)
+		}
+	}
+}
+
+func (r *runReport) set(domain string, res DomainResult) {
+	res.Time = time.Now()
+	r.mu.Lock()
+	r.result.Domains[domain] = res
+	r.mu.Unlock()
+}
+
+// setGC 记录本次清理旧证书的结果
+func (r *runReport) setGC(gc *GCReport) {
+	r.mu.Lock()
+	r.result.GC = gc
+	r.mu.Unlock()
+}
+
+// finish 结束本次同步并返回结果的拷贝
+func (r *runReport) finish(err error) RunResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result.FinishedAt = time.Now()
+	if err != nil {
+		r.result.Error = redact.String(err.Error())
+	}
+	result := r.result
+	result.Domains = maps.Clone(r.result.Domains)
+	result.Deferred = maps.Clone(r.result.Deferred)
+	result.Unavailable = maps.Clone(r.result.Unavailable)
+	return result
+}
@@ -0,0 +1,108 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	robfigcron "github.com/robfig/cron/v3"
+)
+
+// DefaultDuration 未配置 ssl.duration 和 ssl.cron 时的默认执行间隔
+const DefaultDuration = 5 * time.Minute
+
+// Schedule 根据上一次的时间计算下一次执行的时间
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule 固定间隔执行
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// NewSchedule 根据配置生成调度规则,cron 表达式优先于固定间隔
+func NewSchedule(spec string, interval time.Duration) (Schedule, error) {
+	if spec != "" {
+		// 支持标准的五段式表达式以及 @daily、@every 1h 之类的描述符
+		schedule, err := robfigcron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("cron表达式 %q 解析失败:%w", spec, err)
+		}
+		return schedule, nil
+	}
+
+	if interval < 0 {
+		return nil, fmt.Errorf("执行间隔不能为负数:%s", interval)
+	}
+	if interval == 0 {
+		interval = DefaultDuration
+	}
+	return intervalSchedule{interval: interval}, nil
+}
+
+// Scheduler 负责按照调度规则循环执行任务,并记录下一次执行的时间
+type Scheduler struct {
+	schedule Schedule
+	jitter   time.Duration
+
+	mu      sync.RWMutex
+	nextRun time.Time
+}
+
+func NewScheduler(schedule Schedule, jitter time.Duration) *Scheduler {
+	if jitter < 0 {
+		jitter = 0
+	}
+	return &Scheduler{
+		schedule: schedule,
+		jitter:   jitter,
+	}
+}
+
+// NextRun 返回下一次执行的时间,尚未调度时返回零值
+func (s *Scheduler) NextRun() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nextRun
+}
+
+// Run 立即执行一次任务,之后按照调度规则循环执行,直到 ctx 被取消
+func (s *Scheduler) Run(ctx context.Context, job func()) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job()
+
+		next := s.next(time.Now())
+		s.mu.Lock()
+		s.nextRun = next
+		s.mu.Unlock()
+
+		log.Printf("下一次执行时间:%s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// next 计算下一次执行时间并加上随机抖动,避免多个实例同时请求七牛云
+func (s *Scheduler) next(now time.Time) time.Time {
+	next := s.schedule.Next(now)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	return next
+}
@@ -0,0 +1,87 @@
+package cron
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		spec     string
+		interval time.Duration
+		want     time.Time
+		wantErr  bool
+	}{
+		{"default interval", "", 0, now.Add(DefaultDuration), false},
+		{"interval", "", 10 * time.Minute, now.Add(10 * time.Minute), false},
+		{"negative interval", "", -time.Minute, time.Time{}, true},
+		{"cron", "0 3 * * *", 0, time.Date(2026, 3, 2, 3, 0, 0, 0, time.Local), false},
+		{"cron same day", "45 10 * * *", 0, time.Date(2026, 3, 1, 10, 45, 0, 0, time.Local), false},
+		{"descriptor", "@every 1h", 0, now.Add(time.Hour), false},
+		// cron 表达式优先于固定间隔
+		{"cron wins", "@daily", 10 * time.Minute, time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local), false},
+		{"invalid cron", "61 * * * *", 0, time.Time{}, true},
+		{"six fields", "0 0 3 * * *", 0, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := NewSchedule(tt.spec, tt.interval)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewSchedule(%q, %s) succeeded", tt.spec, tt.interval)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 不带抖动时下一次执行时间与调度规则一致
+			if got := NewScheduler(schedule, 0).next(now); !got.Equal(tt.want) {
+				t.Fatalf("next(%s) = %s, want %s", now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.Local)
+	base := now.Add(time.Hour)
+	s := NewScheduler(intervalSchedule{interval: time.Hour}, time.Minute)
+	for range 1000 {
+		if got := s.next(now); got.Before(base) || !got.Before(base.Add(time.Minute)) {
+			t.Fatalf("next() = %s, want within [%s, %s)", got, base, base.Add(time.Minute))
+		}
+	}
+
+	// 负数的抖动视为不抖动
+	if got := NewScheduler(intervalSchedule{interval: time.Hour}, -time.Minute).next(now); !got.Equal(base) {
+		t.Fatalf("next() with negative jitter = %s, want %s", got, base)
+	}
+}
+
+func TestSchedulerNextRun(t *testing.T) {
+	s := NewScheduler(intervalSchedule{interval: time.Hour}, 0)
+	if !s.NextRun().IsZero() {
+		t.Fatalf("NextRun() before Run = %s, want zero", s.NextRun())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	before := time.Now()
+	// 任务执行完后取消,Run 记录下一次执行时间后退出
+	s.Run(ctx, func() {
+		runs++
+		cancel()
+	})
+	after := time.Now()
+	if runs != 1 {
+		t.Fatalf("job ran %d times, want 1", runs)
+	}
+	next := s.NextRun()
+	if next.Before(before.Add(time.Hour)) || next.After(after.Add(time.Hour)) {
+		t.Fatalf("NextRun() = %s, want about %s", next, before.Add(time.Hour))
+	}
+}
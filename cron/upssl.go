@@ -4,182 +4,555 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/muxi-Infra/autossl-qiniuyun/config"
 	"github.com/muxi-Infra/autossl-qiniuyun/dao"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/email"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/metrics"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/ssl"
 	"github.com/samber/lo"
-	"gorm.io/gorm"
 )
 
 const (
 	ExpirationThreshold = 20 // 证书过期阈值（天）,因为certMagic好像是剩余30天及以上才能续约
 	SecondsPerDay       = 24 * 60 * 60
+
+	DefaultShutdownTimeout = 2 * time.Minute // 退出时等待正在处理的域名组的默认时间
 )
 
 type QiniuSSL struct {
 	qiniuClient *qiniu.QiniuClient
-	sslDAO      *dao.SSLDao
+	sslDAO      dao.Store
 	cmClient    *ssl.CertMagicClient
+	dnsRouter   *ssl.DNSRouter
+	psl         *psl.Resolver
+	pslConf     config.PSLConf
 	emailClient *email.EmailClient
 	receiver    string
-	duration    time.Duration
+	scheduler   *Scheduler
+	// 退出信号到来后,正在处理的域名组最多还能运行的时间
+	shutdownTimeout time.Duration
+	// 只输出计划,不申请、上传、绑定或保存证书
+	dryRun bool
+
+	httpsPolicy httpsPolicy
+
+	gcEnabled   bool
+	gcRetention time.Duration
+	// 同步记录保留的时间,为 0 时不清理
+	runRetention time.Duration
+
+	// 同一时间只允许一次同步或续期,lockOwner 用于与其他进程互斥
+	runMu     sync.Mutex
+	lockOwner string
+	// 通过管理接口触发、正在后台执行的任务,退出前需要等待
+	jobs       sync.WaitGroup
+	resultMu   sync.RWMutex
+	lastResult RunResult
 }
 
-func NewQiniuSSL() (*QiniuSSL, error) {
-	//获取所有相关配置
-	conf, err := config.GetConfig()
+// ErrBusy 已经有同步或续期正在进行
+var ErrBusy = errors.New("已有同步任务正在执行")
+
+func NewQiniuSSL(
+	conf *config.Conf,
+	qiniuClient *qiniu.QiniuClient,
+	sslDAO dao.Store,
+	emailClient *email.EmailClient,
+) (*QiniuSSL, error) {
+	dnsRouter, err := newDNSRouter(conf.SSL)
 	if err != nil {
 		return nil, err
 	}
-	qiniuClient := qiniu.NewQiniuClient(
-		conf.Qiniu.AccessKey,
-		conf.Qiniu.SecretKey,
-	)
 
-	emailClient := email.NewEmailClient(
-		conf.Email.UserName,
-		conf.Email.Password,
-		conf.Email.Sender,
-		conf.Email.SmtpHost,
-		conf.Email.SmtpPort,
-	)
+	cmClient, err := ssl.NewCertMagicClient(conf.SSL.Email, conf.SSL.SSLPath, dnsRouter)
+	if err != nil {
+		return nil, err
+	}
 
-	sslDAO, err := dao.NewSSLDao(conf.SSL.DB)
+	schedule, err := NewSchedule(conf.SSL.Cron, conf.SSL.Duration)
 	if err != nil {
 		return nil, err
 	}
 
-	provider := ssl.NewProvider(
-		ssl.Aliyun,
-		conf.SSL.Aliyun.AccessKeyID,
-		conf.SSL.Aliyun.AccessKeySecret,
-		"",
-	)
+	shutdownTimeout := conf.SSL.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+
+	gcRetention := conf.SSL.GC.Retention
+	if gcRetention <= 0 {
+		gcRetention = DefaultGCRetention
+	}
+
+	runRetention := conf.SSL.History.Retention
+	if runRetention <= 0 {
+		runRetention = DefaultRunRetention
+	}
 
-	cmClient, err := ssl.NewCertMagicClient(conf.SSL.Email, conf.SSL.SSLPath, provider)
+	q := &QiniuSSL{
+		qiniuClient:     qiniuClient,
+		emailClient:     emailClient,
+		sslDAO:          sslDAO,
+		cmClient:        cmClient,
+		dnsRouter:       dnsRouter,
+		psl:             psl.NewResolver(),
+		pslConf:         conf.SSL.PSL,
+		receiver:        conf.Email.Receiver,
+		scheduler:       NewScheduler(schedule, conf.SSL.Jitter),
+		shutdownTimeout: shutdownTimeout,
+		dryRun:          conf.SSL.DryRun,
+		httpsPolicy:     httpsPolicy{conf: conf.SSL.HTTPS},
+		gcEnabled:       conf.SSL.GC.Enabled,
+		gcRetention:     gcRetention,
+		runRetention:    runRetention,
+		lockOwner:       newLockOwner(),
+	}
+	if q.dryRun {
+		log.Println("当前为 dry-run 模式,只输出计划,不会修改任何证书和域名")
+	}
+
+	// 证书过期指标在每次抓取时从数据库读取
+	if err := metrics.RegisterCerts(q.certMetrics); err != nil {
+		log.Printf("注册证书指标失败:%v", err)
+	}
+	if err := metrics.RegisterDomains(q.domainMetrics); err != nil {
+		log.Printf("注册域名指标失败:%v", err)
+	}
+	return q, nil
+}
+
+// certMetrics 为证书过期指标提供数据
+func (q *QiniuSSL) certMetrics() ([]metrics.Cert, error) {
+	ssls, err := q.ListSSL()
 	if err != nil {
 		return nil, err
 	}
-
-	return &QiniuSSL{
-		qiniuClient: qiniuClient,
-		emailClient: emailClient,
-		sslDAO:      sslDAO,
-		cmClient:    cmClient,
-		receiver:    conf.Email.Receiver,
-		duration:    conf.SSL.Duration,
-	}, nil
+	return lo.Map(ssls, func(s dao.SSL, _ int) metrics.Cert {
+		return metrics.Cert{CertID: s.CertID, Domain: s.DomainName, NotAfter: s.NotAfter}
+	}), nil
 }
 
-func (q *QiniuSSL) Start() {
-	run := func() error {
-		//按照父域名对域名进行分组
-		domainGroups, err := q.getDomainGroups()
-		if err != nil {
-			//发送邮件
-			err := q.emailClient.SendEmail([]string{q.receiver}, "七牛云自动报警服务", fmt.Sprintf("域名列表分组失败!:%s", err.Error()), "", nil)
-			if err != nil {
-				log.Println(err)
-				return err
-			}
+// domainMetrics 为域名绑定状态指标提供数据:数据库中已绑定的域名为成功,
+// 有绑定失败记录或最近一次同步失败的域名为失败,因此重启后仍然能反映绑定状态
+func (q *QiniuSSL) domainMetrics() ([]metrics.Domain, error) {
+	states := make(map[string]metrics.Domain)
+	ssls, err := q.ListSSL()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range ssls {
+		for _, d := range s.Domains {
+			states[d.Name] = metrics.Domain{Domain: d.Name, Group: s.DomainName, Bound: true}
 		}
+	}
 
-		for domain, list := range domainGroups {
-			err := q.startStrategy(context.Background(), domain, list)
-			if err != nil {
-				// 发送邮件
-				err := q.emailClient.SendEmail([]string{q.receiver}, "七牛云自动报警服务", fmt.Sprintf("启动证书失败:%s", err.Error()), "", nil)
-				if err != nil {
-					log.Println(err)
-				}
-				continue
-			}
+	failures, err := q.sslDAO.GetBindFailures()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range failures {
+		states[f.Domain] = metrics.Domain{Domain: f.Domain, Group: q.groupName(f.Domain), Bound: false}
+	}
+
+	// 最近一次同步的结果最新,包括没有写入数据库的整组失败
+	for domain, res := range q.LastResult().Domains {
+		states[domain] = metrics.Domain{Domain: domain, Group: res.Group, Bound: res.Success}
+	}
+	return lo.Values(states), nil
+}
+
+// groupName 返回域名所属的域名组,无法解析时为空
+func (q *QiniuSSL) groupName(domain string) string {
+	_, registrable, err := coverName(q.psl, domain)
+	if err != nil {
+		return ""
+	}
+	return registrable
+}
+
+// newDNSRouter 从 ssl.dns 读取默认 DNS 平台及按域名后缀划分的平台,兼容旧的 ssl.aliyun 配置
+func newDNSRouter(conf config.SSLConf) (*ssl.DNSRouter, error) {
+	fallback := ssl.NewProvider(
+		conf.DNS.Platform,
+		conf.DNS.AccessKeyID,
+		conf.DNS.AccessKeySecret,
+		conf.DNS.Token,
+	)
+	if fallback.Platform == "" && conf.Aliyun.AccessKeyID != "" {
+		log.Println("ssl.aliyun 配置已废弃,请迁移到 ssl.dns")
+		fallback = ssl.NewProvider(ssl.Aliyun, conf.Aliyun.AccessKeyID, conf.Aliyun.AccessKeySecret, "")
+	}
+
+	// 只配置了 zones 时允许没有默认平台
+	if fallback.Platform != "" || len(conf.DNS.Zones) == 0 {
+		if err := fallback.Validate(); err != nil {
+			return nil, fmt.Errorf("ssl.dns 配置错误:%w", err)
 		}
-		return nil
 	}
 
-	//首次启动进行的操作
-	//强制为所有的域名申请证书
-	for {
+	routes := make([]ssl.Route, 0, len(conf.DNS.Zones))
+	for _, zone := range conf.DNS.Zones {
+		routes = append(routes, ssl.Route{
+			Suffix:   zone.Suffix,
+			Provider: ssl.NewProvider(zone.Platform, zone.AccessKeyID, zone.AccessKeySecret, zone.Token),
+		})
+	}
+
+	router, err := ssl.NewDNSRouter(fallback, routes)
+	if err != nil {
+		return nil, fmt.Errorf("ssl.dns.zones 配置错误:%w", err)
+	}
+	return router, nil
+}
 
-		if err := run(); err != nil {
+func (q *QiniuSSL) Start(ctx context.Context) {
+	//首次启动时立即执行一次,之后按照 ssl.cron 或 ssl.duration 调度
+	q.scheduler.Run(ctx, func() {
+		q.runMu.Lock()
+		defer q.runMu.Unlock()
+		// 一次性命令正在执行时跳过本次同步,等待下一次调度
+		release, err := q.lockStore()
+		if err != nil {
+			log.Printf("跳过本次同步:%v", err)
+			return
+		}
+		defer release()
+		if err := q.run(ctx); err != nil {
 			log.Println(err)
 		}
+	})
+}
 
-		// 停五分钟等待
-		time.Sleep(5 * time.Minute)
+// Sync 立即执行一次完整的同步,已有任务在执行时返回 ErrBusy
+func (q *QiniuSSL) Sync(ctx context.Context) error {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
+	return q.run(ctx)
+}
 
+// SyncAsync 在后台执行一次完整的同步,已有任务在执行时立即返回 ErrBusy,结果通过 LastResult 查看
+func (q *QiniuSSL) SyncAsync(ctx context.Context) error {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return err
+	}
+	q.jobs.Add(1)
+	go func() {
+		defer q.jobs.Done()
+		defer unlock()
+		if err := q.run(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+	return nil
 }
 
-func (q *QiniuSSL) startStrategy(ctx context.Context, fatherDomain string, domains []string) error {
-	var (
-		now = time.Now()
-	)
+// Renew 强制为某个可注册域名重新申请证书并绑定组内全部域名,已有任务在执行时返回 ErrBusy
+func (q *QiniuSSL) Renew(ctx context.Context, name string) error {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return q.renew(ctx, name)
+}
+
+// RenewAsync 在后台执行 Renew,已有任务在执行时立即返回 ErrBusy,结果通过 LastResult 查看
+func (q *QiniuSSL) RenewAsync(ctx context.Context, name string) error {
+	unlock, err := q.tryLock()
+	if err != nil {
+		return err
+	}
+	q.jobs.Add(1)
+	go func() {
+		defer q.jobs.Done()
+		defer unlock()
+		if err := q.renew(ctx, name); err != nil {
+			q.notify(ctx, fmt.Sprintf("手动续期证书失败:%s", err.Error()))
+		}
+	}()
+	return nil
+}
 
-	sslCredit, err := q.sslDAO.GetSSLByName(fatherDomain)
+func (q *QiniuSSL) renew(ctx context.Context, name string) error {
+	domainGroups, err := q.groupDomains(ctx)
 	if err != nil {
-		return fmt.Errorf("从数据库获取证书失败:%w", err)
+		return err
+	}
+	group, ok := domainGroups[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("七牛云上没有属于 %s 的域名", name)
 	}
+	group.Force = true
 
-	// 如果查询不到直接获取最新的
-	if sslCredit.ID == 0 {
-		sslCredit, err = q.obtainSSLCredit(ctx, fatherDomain)
+	if q.dryRun {
+		plan, _, err := q.decide(ctx, group)
 		if err != nil {
 			return err
 		}
+		printPlan([]GroupPlan{plan})
+		return nil
+	}
+
+	// 与定时同步相同,退出信号到来后仍给正在处理的域名组留出收尾时间
+	groupCtx, cancel := graceContext(ctx, q.shutdownTimeout)
+	defer cancel()
+
+	report := q.beginRun(runKindRenew, group.Name)
+	err = q.startStrategy(groupCtx, group, report)
+	q.endRun(report, err)
+	return err
+}
+
+// Wait 等待 SyncAsync 和 RenewAsync 启动的后台任务结束,
+// 任务在退出信号到来 shutdownTimeout 后会被取消,因此最多等待 shutdownTimeout
+func (q *QiniuSSL) Wait() {
+	done := make(chan struct{})
+	go func() {
+		q.jobs.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(q.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("等待后台任务超过 %s,不再等待", q.shutdownTimeout)
 	}
+}
 
-	// 如果过期则先删除后获取
-	if !checkIfPass(now.Unix(), sslCredit.NotAfter.Unix()) {
-		// 删除已经失效的证书
-		err = q.sslDAO.DeleteSSL(sslCredit.CertID)
+// LastResult 返回最近一次同步或续期的结果
+func (q *QiniuSSL) LastResult() RunResult {
+	q.resultMu.RLock()
+	defer q.resultMu.RUnlock()
+	return q.lastResult
+}
+
+func (q *QiniuSSL) setLastResult(result RunResult) {
+	q.resultMu.Lock()
+	q.lastResult = result
+	q.resultMu.Unlock()
+}
+
+// ListSSL 返回数据库中的全部证书
+func (q *QiniuSSL) ListSSL() ([]dao.SSL, error) {
+	return q.sslDAO.ListSSLs()
+}
+
+// run 执行一次完整的同步流程
+func (q *QiniuSSL) run(ctx context.Context) (err error) {
+	q.refreshPSL(ctx)
+
+	// dry-run 模式下只输出计划,不产生任何修改
+	if q.dryRun {
+		plans, err := q.Plan(ctx)
 		if err != nil {
-			return fmt.Errorf("certID:%s ,删除证书失败:%w", sslCredit.CertID, err)
+			return err
 		}
-		sslCredit, err = q.obtainSSLCredit(ctx, fatherDomain)
+		printPlan(plans)
+		q.runGC(ctx)
+		return nil
+	}
+
+	report := q.beginRun(runKindSync, "")
+	defer func() {
+		q.endRun(report, err)
+	}()
+
+	//按照可注册域名对域名进行分组
+	domainGroups, err := q.getDomainGroups(ctx)
+	if err != nil {
+		//发送邮件
+		q.notify(ctx, fmt.Sprintf("域名列表分组失败!:%s", err.Error()))
+		return err
+	}
+
+	for _, group := range domainGroups {
+		// 收到退出信号后不再处理新的域名组
+		if ctx.Err() != nil {
+			log.Println("收到退出信号,停止处理剩余的域名组")
+			return nil
+		}
+
+		for domain, state := range group.Skipped {
+			report.skip(domain, state)
+		}
+		for domain, next := range group.Retrying {
+			report.retryAt(domain, next)
+		}
+
+		// 正在处理的域名组在退出信号到来后还有 shutdownTimeout 的时间完成
+		groupCtx, cancel := graceContext(ctx, q.shutdownTimeout)
+		err := q.startStrategy(groupCtx, group, report)
+		cancel()
 		if err != nil {
-			return err
+			// 发送邮件
+			q.notify(ctx, fmt.Sprintf("启动证书失败:%s", err.Error()))
+			continue
+		}
+	}
+
+	// 所有域名处理完成后再清理旧证书,此时被替换的证书上不应再绑定域名
+	gc := q.runGC(ctx)
+	if gc != nil {
+		for _, cert := range gc.Deleted {
+			report.audit(dao.AuditActionDelete, cert.DomainName, cert.CertID, retiredMessage(cert.ReplacedBy, cert.RetiredAt))
 		}
 	}
+	report.setGC(gc)
+	return nil
+}
+
+// refreshPSL 按需更新 Public Suffix List,失败时继续使用之前的列表
+func (q *QiniuSSL) refreshPSL(ctx context.Context) {
+	if q.pslConf.RefreshInterval > 0 && q.psl.Stale(q.pslConf.RefreshInterval) {
+		if err := q.psl.Refresh(ctx, http.DefaultClient, q.pslConf.URL); err != nil {
+			log.Printf("更新 Public Suffix List 失败:%v", err)
+		}
+	}
+}
+
+// notify 发送报警邮件,退出过程中产生的错误只记录日志
+func (q *QiniuSSL) notify(ctx context.Context, text string) {
+	// 通知内容可能来自第三方返回的错误,发送前去除证书、私钥和密钥
+	text = redact.String(text)
+	if ctx.Err() != nil {
+		log.Println(text)
+		return
+	}
+	err := q.emailClient.SendEmail([]string{q.receiver}, "七牛云自动报警服务", text, "", nil)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// graceContext 返回一个在 parent 取消后,再经过 grace 才会被取消的 context
+func graceContext(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-parent.Done():
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+// NextRun 返回下一次执行的时间
+func (q *QiniuSSL) NextRun() time.Time {
+	return q.scheduler.NextRun()
+}
+
+func (q *QiniuSSL) startStrategy(ctx context.Context, group *domainGroup, report *runReport) (err error) {
+	domains := group.Domains
+
+	// 整组失败时,所有待绑定的域名都记为失败
+	defer func() {
+		if err != nil {
+			report.failUnrecorded(group.Name, domains, err)
+			if len(domains) == 0 {
+				report.audit(dao.AuditActionError, group.Name, "", err.Error())
+			}
+		}
+	}()
 
-	// 从七牛云获取证书
-	resp, err := q.qiniuClient.GETSSLCertById(sslCredit.CertID)
+	plan, sslCredit, err := q.decide(ctx, group)
 	if err != nil {
-		return fmt.Errorf("certID:%s ,从七牛云获取证书失败:%w", sslCredit.CertID, err)
+		return err
 	}
+	domains = append(slices.Clone(plan.Bind), plan.Reconcile...)
 
-	// 如果七牛云已经失效则删除并重新获取
-	if !checkIfPass(now.Unix(), int64(resp.Cert.NotAfter)) {
-		// 删除已经失效的证书
-		err = q.sslDAO.DeleteSSL(sslCredit.CertID)
+	switch plan.Action {
+	case ActionSkip:
+		return nil
+	case ActionObtain:
+		// 先获取新证书,成功后再删除旧的记录,避免申请失败时丢失仍在使用的证书
+		log.Printf("%s: %s,申请新证书", group.Name, plan.Reason)
+		newCredit, err := q.obtainSSLCredit(ctx, group)
 		if err != nil {
-			return fmt.Errorf("certID:%s ,删除证书失败:%w", sslCredit.CertID, err)
+			return err
 		}
-		sslCredit, err = q.obtainSSLCredit(ctx, fatherDomain)
+		report.audit(dao.AuditActionObtain, group.Name, newCredit.CertID, fmt.Sprintf("%s, SAN=%v", plan.Reason, newCredit.SANs))
+		report.audit(dao.AuditActionUpload, group.Name, newCredit.CertID, "上传新证书")
+		// 上传后立即在一个事务中替换旧证书,被替换的证书在域名迁移完成后由 gc 从七牛云删除
+		if err := q.sslDAO.ReplaceSSL(sslCredit, newCredit); err != nil {
+			err = fmt.Errorf("domain:%s, certID:%s, 保存或更新证书失败:%w", group.Name, newCredit.CertID, err)
+			return errors.Join(err, q.discardUploaded(ctx, newCredit))
+		}
+		sslCredit = newCredit
+	case ActionReupload:
+		// 七牛云上的证书已被删除,重新上传数据库中仍然有效的证书
+		log.Printf("certID:%s 在七牛云上已被删除,重新上传证书", sslCredit.CertID)
+		oldCertID := sslCredit.CertID
+		sslCredit, err = q.reuploadSSLCredit(ctx, sslCredit)
 		if err != nil {
 			return err
 		}
+		report.audit(dao.AuditActionUpload, group.Name, sslCredit.CertID, "重新上传七牛云上已被删除的 certID:"+oldCertID)
 	}
 
 	var successDomains []dao.Domain
-	// 强制开启各个域名的HTTPS
+	var errs []error
+	// 强制开启各个域名的HTTPS,单个域名失败不影响组内其他域名
 	for _, domain := range domains {
-		err := q.qiniuClient.ForceHTTPS(domain, sslCredit.CertID)
-		if err != nil {
-			return fmt.Errorf("domain:%s, certID:%s, 启用证书失败:%w", domain, sslCredit.CertID, err)
+		changed, err := q.bindDomain(ctx, domain, sslCredit.CertID)
+		var stateErr *domainStateError
+		switch {
+		case errors.As(err, &stateErr):
+			log.Printf("domain:%s 当前状态为 %s,暂不绑定证书", domain, stateErr.State)
+			report.skip(domain, stateErr.State)
+			continue
+		case err != nil:
+			err = fmt.Errorf("domain:%s, certID:%s, 启用证书失败:%w", domain, sslCredit.CertID, err)
+			report.fail(group.Name, domain, sslCredit.CertID, err)
+			// 退出时被取消的请求不是域名本身的问题,不计入退避,下一次启动后立即重试
+			if ctx.Err() == nil {
+				if err := q.recordBindFailure(domain, sslCredit.CertID, err); err != nil {
+					log.Printf("domain:%s 记录绑定失败原因失败:%v", domain, err)
+				}
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if err := q.sslDAO.ClearBindFailure(domain); err != nil {
+			log.Printf("domain:%s 清除绑定失败记录失败:%v", domain, err)
+		}
+		if changed {
+			reason := plan.Reason
+			if slices.Contains(plan.Reconcile, domain) {
+				reason = "证书或 https 选项与配置不一致"
+			}
+			report.audit(dao.AuditActionBind, domain, sslCredit.CertID, reason)
+		} else {
+			log.Printf("domain:%s 已经绑定到 certID:%s,跳过", domain, sslCredit.CertID)
 		}
+		report.success(group.Name, domain, sslCredit.CertID)
 		successDomains = append(successDomains, dao.Domain{Name: domain})
-
-		//防止被七牛云限流
-		time.Sleep(5 * time.Second)
 	}
 
 	// 获取去重后的结果并保存
@@ -192,17 +565,58 @@ func (q *QiniuSSL) startStrategy(ctx context.Context, fatherDomain string, domai
 		return fmt.Errorf("domain:%s, certID:%s, 保存或更新证书失败:%w", sslCredit.DomainName, sslCredit.CertID, err)
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// reuploadSSLCredit 将数据库中的证书重新上传到七牛云,并替换掉旧的证书记录
+func (q *QiniuSSL) reuploadSSLCredit(ctx context.Context, old *dao.SSL) (*dao.SSL, error) {
+	// 只有重新上传时才需要私钥明文
+	if err := q.sslDAO.DecryptKey(old); err != nil {
+		return nil, err
+	}
+	resp, err := q.qiniuClient.UPSSLCert(ctx, old.KeyPEM, old.CertPEM, old.DomainName)
+	if err != nil {
+		return nil, fmt.Errorf("Domain:%s,重新上传证书失败:%w", old.DomainName, redact.Error(err))
+	}
+
+	// 旧证书已经不存在,绑定关系需要重新建立
+	sslCredit := &dao.SSL{
+		DomainName: old.DomainName,
+		CertID:     resp.CertID,
+		CertPEM:    old.CertPEM,
+		KeyPEM:     old.KeyPEM,
+		NotAfter:   old.NotAfter,
+		SANs:       sslSANs(old),
+	}
+	if err := q.sslDAO.ReplaceSSL(old, sslCredit); err != nil {
+		err = fmt.Errorf("domain:%s, certID:%s, 保存或更新证书失败:%w", old.DomainName, sslCredit.CertID, err)
+		return nil, errors.Join(err, q.discardUploaded(ctx, sslCredit))
+	}
+	return sslCredit, nil
 }
 
-func (q *QiniuSSL) obtainSSLCredit(ctx context.Context, fatherDomain string) (*dao.SSL, error) {
-	var sslCredit *dao.SSL
+// obtainSSLCredit 申请一张覆盖组内全部 SAN 的证书并上传到七牛云
+func (q *QiniuSSL) obtainSSLCredit(ctx context.Context, group *domainGroup) (*dao.SSL, error) {
+	// 确认每个名称都有对应的 DNS 平台,DNS-01 验证时由 dnsRouter 分发到该平台
+	for _, san := range group.SANs {
+		platform, err := q.dnsRouter.Platform(san)
+		if err != nil {
+			return nil, fmt.Errorf("域名:%s ,没有可用的DNS平台:%w", san, err)
+		}
+		log.Printf("域名:%s ,使用 %s 进行DNS验证", san, platform)
+	}
+
 	// 尝试获取证书
-	certPEM, keyPEM, err := q.cmClient.ObtainCert(ctx, "*."+fatherDomain)
+	certPEM, keyPEM, err := q.cmClient.ObtainCert(ctx, group.SANs...)
+	metrics.ObserveACMEObtain(group.Name, err)
 	if err != nil {
-		return nil, fmt.Errorf("域名:%s ,获取证书失败:%w", "*."+fatherDomain, err)
+		return nil, fmt.Errorf("域名:%s ,获取证书失败:%w", strings.Join(group.SANs, ","), err)
 	}
+	return q.uploadSSLCredit(ctx, group.Name, certPEM, keyPEM)
+}
 
+// uploadSSLCredit 解析新申请的证书并上传到七牛云,返回的记录中还没有绑定任何域名
+func (q *QiniuSSL) uploadSSLCredit(ctx context.Context, name, certPEM, keyPEM string) (*dao.SSL, error) {
 	// 解析证书并获取过期时间
 	block, _ := pem.Decode([]byte(certPEM))
 	if block == nil {
@@ -214,22 +628,20 @@ func (q *QiniuSSL) obtainSSLCredit(ctx context.Context, fatherDomain string) (*d
 		return nil, fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
-	// 上传证书
-	resp, err := q.qiniuClient.UPSSLCert(keyPEM, certPEM, fatherDomain)
+	// 上传证书,错误中只包含证书摘要,七牛云返回的错误信息可能原样带回证书或私钥,同样需要去除
+	resp, err := q.qiniuClient.UPSSLCert(ctx, keyPEM, certPEM, name)
 	if err != nil {
-		return nil, fmt.Errorf("keyPEM:%s ,certPEM:%s ,Domain:%s,上传证书失败:%w", keyPEM, certPEM, fatherDomain, err)
+		return nil, fmt.Errorf("证书:%s ,Domain:%s,上传证书失败:%w", redact.Summarize(cert), name, redact.Error(err))
 	}
 
-	// 构建数据模型,注意此时是没有存入任何的子域名的
-	sslCredit = &dao.SSL{
-		DomainName: fatherDomain,
+	return &dao.SSL{
+		DomainName: name,
 		CertID:     resp.CertID,
 		CertPEM:    certPEM,
 		KeyPEM:     keyPEM,
 		NotAfter:   cert.NotAfter,
-	}
-
-	return sslCredit, nil
+		SANs:       cert.DNSNames,
+	}, nil
 }
 
 func checkIfPass(now, t int64) bool {
@@ -237,43 +649,99 @@ func checkIfPass(now, t int64) bool {
 	return t-now > ExpirationThreshold*SecondsPerDay
 }
 
-// getDomainGroups 获取所有域名，并按父域名分组
-func (q *QiniuSSL) getDomainGroups() (map[string][]string, error) {
-	domainGroups := make(map[string][]string)
-	domainList, err := q.qiniuClient.GetDomainList()
+// getDomainGroups 获取所有域名，并按可注册域名分组,每组只保留还需要绑定证书的域名
+func (q *QiniuSSL) getDomainGroups(ctx context.Context) (map[string]*domainGroup, error) {
+	domainGroups, err := q.groupDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 从需要处理的表格中删除所有已经在符合条件的证书下的域名
+	for registrable, group := range domainGroups {
+		// 获取已存储的域名及证书
+		stored, err := q.sslDAO.GetSSLByName(registrable)
+		if err != nil {
+			return nil, err
+		}
+		if stored.ID == 0 {
+			continue
+		}
+
+		now := time.Now().Unix()
+		// 如果证书未过期且覆盖了全部名称，则去除已存储的域名
+		if checkIfPass(now, stored.NotAfter.Unix()) && coversAll(sslSANs(stored), group.SANs) {
+			storedDomains := lo.Map(stored.Domains, func(d dao.Domain, _ int) string {
+				return d.Name
+			})
+			unstored := filterUnstoredDomains(group.Domains, storedDomains)
+			group.Stored = lo.Without(group.Domains, unstored...)
+			group.Domains = unstored
+			// 已经绑定的域名不受运行状态影响,冻结或下线的域名不在 Stored 中,仍然保留在 Skipped
+			for _, name := range group.Stored {
+				delete(group.Skipped, name)
+			}
+		}
+	}
+
+	// 绑定失败的域名按照退避时间重试,不会导致整组重新处理
+	if err := q.applyBindBackoff(domainGroups); err != nil {
+		return nil, err
+	}
+	return domainGroups, nil
+}
+
+// groupDomains 获取所有域名，并按可注册域名分组,每组共用一张多 SAN 证书
+func (q *QiniuSSL) groupDomains(ctx context.Context) (map[string]*domainGroup, error) {
+	domainGroups := make(map[string]*domainGroup)
+	groupNames := make(map[string][]string)
+	domainList, err := q.qiniuClient.GetDomainList(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get domain list: %w", err)
 	}
 
-	// 按父域名分组
+	// 按可注册域名分组
 	for _, domain := range domainList.Domains {
-		parentDomain, err := getParentDomain(domain.Name)
+		// 七牛云测试域名无法绑定自有证书
+		if domain.Type == qiniu.DomainTypeTest {
+			continue
+		}
+		name, registrable, err := coverName(q.psl, domain.Name)
 		if err != nil {
-			fmt.Printf("无法解析域名 %s: %v\n", domain.Name, err)
+			log.Printf("无法解析域名 %s: %v", domain.Name, err)
 			continue
 		}
-		domainGroups[parentDomain] = append(domainGroups[parentDomain], domain.Name)
-	}
 
-	// 从需要处理的表格中删除所有已经在符合条件的证书下的域名
-	for parentDomain, domains := range domainGroups {
-		// 获取已存储的域名及证书过期时间
-		notAfter, storedDomains, err := q.sslDAO.GetDomains(parentDomain)
-		switch err {
-		case nil:
-		case gorm.ErrRecordNotFound:
+		group, ok := domainGroups[registrable]
+		if !ok {
+			group = &domainGroup{
+				Name:     registrable,
+				Skipped:  make(map[string]string),
+				Retrying: make(map[string]time.Time),
+			}
+			domainGroups[registrable] = group
+		}
+
+		switch domain.OperatingState {
+		case qiniu.OperatingStateFrozen, qiniu.OperatingStateOfflined:
+			// 冻结或下线的域名无法修改配置,也不需要证书覆盖
+			group.Skipped[domain.Name] = domain.OperatingState
 			continue
+		case qiniu.OperatingStateProcessing:
+			// 配置下发中的域名本次不绑定,但证书仍需覆盖它,下一次同步时再绑定
+			group.Skipped[domain.Name] = domain.OperatingState
 		default:
-			return nil, err
+			group.Domains = append(group.Domains, domain.Name)
 		}
+		groupNames[registrable] = append(groupNames[registrable], name)
+	}
 
-		now := time.Now().Unix()
-		// 如果证书未过期，则去除已存储的域名
-		if checkIfPass(now, notAfter) {
-			domainGroups[parentDomain] = filterUnstoredDomains(domains, storedDomains)
+	for registrable, group := range domainGroups {
+		// 组内的域名都已冻结或下线时不需要证书,SANs 保持为空,否则只会得到一张仅包含可注册域名的证书
+		if len(groupNames[registrable]) == 0 {
+			continue
 		}
+		group.SANs = computeSANs(registrable, groupNames[registrable])
 	}
-
 	return domainGroups, nil
 }
 
@@ -292,21 +760,3 @@ func filterUnstoredDomains(allDomains, storedDomains []string) []string {
 	}
 	return result
 }
-
-func getParentDomain(domain string) (string, error) {
-
-	//如果是以.开头的话直接返回,表示是为了某个泛用域名做申请
-	if strings.HasPrefix(domain, ".") {
-		return strings.TrimPrefix(domain, "."), nil
-	}
-
-	// 拆分域名
-	parts := strings.Split(domain, ".")
-	// 如果域名部分少于两段，说明已经是顶级域名了
-	if len(parts) < 2 {
-		return "", fmt.Errorf("no parent domain for %s", domain)
-	}
-
-	// 组合剩余部分返回
-	return strings.Join(parts[1:], "."), nil
-}
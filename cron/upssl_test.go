@@ -0,0 +1,148 @@
+package cron
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/metrics"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/psl"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+)
+
+// newTestCert 生成一张自签名证书及其私钥
+func newTestCert(t *testing.T, names ...string) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM
+}
+
+// captureLog 将标准库 log 的输出重定向到返回的 buffer,测试结束后恢复
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestUploadSSLCreditRedactsError(t *testing.T) {
+	certPEM, keyPEM := newTestCert(t, "example.com", "*.example.com")
+
+	// 七牛云将请求中的私钥原样带回错误信息
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req qiniu.UPSSLCertReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": 400, "error": "invalid pri: " + req.Pri})
+	}))
+	defer srv.Close()
+
+	q := &QiniuSSL{qiniuClient: qiniu.NewQiniuClient("ak", "sk", qiniu.WithBaseURL(srv.URL))}
+	_, err := q.uploadSSLCredit(context.Background(), "example.com", certPEM, keyPEM)
+	if err == nil {
+		t.Fatal("upload succeeded, want error")
+	}
+
+	msg := err.Error()
+	keyBody := strings.Split(keyPEM, "\n")[1]
+	if strings.Contains(msg, keyBody) || strings.Contains(msg, "BEGIN CERTIFICATE") {
+		t.Fatalf("error leaks certificate or key: %s", msg)
+	}
+	if !strings.Contains(msg, "[REDACTED EC PRIVATE KEY]") || !strings.Contains(msg, "sha256:") {
+		t.Fatalf("error = %s, want redacted key and certificate summary", msg)
+	}
+	var apiErr *qiniu.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("error does not wrap APIError: %v", err)
+	}
+}
+
+func TestNotifyRedactsText(t *testing.T) {
+	_, keyPEM := newTestCert(t, "example.com")
+	buf := captureLog(t)
+
+	// 已经取消的 context 只记录日志,不发送邮件
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q := &QiniuSSL{}
+	q.notify(ctx, "保存证书失败:"+keyPEM)
+
+	out := buf.String()
+	if strings.Contains(out, strings.Split(keyPEM, "\n")[1]) {
+		t.Fatalf("notify leaks private key: %s", out)
+	}
+	if !strings.Contains(out, "保存证书失败:[REDACTED EC PRIVATE KEY]") {
+		t.Fatalf("notify output = %q", out)
+	}
+}
+
+func TestDomainMetricsFromStore(t *testing.T) {
+	store := dao.NewMemoryStore()
+	err := store.SaveSSL(&dao.SSL{
+		DomainName: "example.com",
+		CertID:     "cert-1",
+		Domains:    []dao.Domain{{Name: "a.example.com"}, {Name: "b.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveBindFailure(&dao.BindFailure{Domain: "b.example.com", CertID: "cert-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新创建的 QiniuSSL 没有任何同步结果,相当于重启后第一次抓取
+	q := &QiniuSSL{sslDAO: store, psl: psl.NewResolver()}
+	domains, err := q.domainMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]metrics.Domain)
+	for _, d := range domains {
+		got[d.Domain] = d
+	}
+	want := map[string]metrics.Domain{
+		"a.example.com": {Domain: "a.example.com", Group: "example.com", Bound: true},
+		"b.example.com": {Domain: "b.example.com", Group: "example.com", Bound: false},
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("domain metrics = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/keyring"
+)
+
+// encrypt 配置了主密钥时加密私钥,从数据库读取后没有解密的私钥原样保存
+func (dao *SSLDao) encrypt(keyPEM string) (string, error) {
+	if dao.keys == nil || keyPEM == "" || keyring.IsEncrypted(keyPEM) {
+		return keyPEM, nil
+	}
+	return dao.keys.Encrypt(keyPEM)
+}
+
+// decrypt 解密从数据库读取的私钥,历史的明文私钥原样返回
+func (dao *SSLDao) decrypt(ssl *SSL) error {
+	if !keyring.IsEncrypted(ssl.KeyPEM) {
+		return nil
+	}
+	if dao.keys == nil {
+		return errors.New("数据库中的私钥已加密,但没有配置主密钥")
+	}
+	keyPEM, err := dao.keys.Decrypt(ssl.KeyPEM)
+	if err != nil {
+		return fmt.Errorf("certID:%s ,解密私钥失败:%w", ssl.CertID, err)
+	}
+	ssl.KeyPEM = keyPEM
+	return nil
+}
+
+// RotateKeys 使用当前主密钥重新加密所有未加密或使用旧主密钥加密的私钥,返回重新加密的数量
+func (dao *SSLDao) RotateKeys() (int, error) {
+	if dao.keys == nil {
+		return 0, errors.New("没有配置主密钥")
+	}
+	return dao.reencryptKeys(dao.keys.NeedsRotation)
+}
+
+// reencryptKeys 在一个事务中重新加密 need 返回 true 的私钥
+func (dao *SSLDao) reencryptKeys(need func(keyPEM string) bool) (int, error) {
+	var ssls []SSL
+	if err := dao.db.Select("id", "cert_id", "key_pem").Find(&ssls).Error; err != nil {
+		return 0, err
+	}
+
+	tx := dao.db.Begin()
+	n := 0
+	for _, ssl := range ssls {
+		if ssl.KeyPEM == "" || !need(ssl.KeyPEM) {
+			continue
+		}
+		if err := dao.decrypt(&ssl); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		keyPEM, err := dao.keys.Encrypt(ssl.KeyPEM)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err := tx.Model(&SSL{}).Where("id = ?", ssl.ID).Update("key_pem", keyPEM).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		n++
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return n, nil
+}
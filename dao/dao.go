@@ -2,36 +2,114 @@ package dao
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/keyring"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 )
 
-// SSLDao 负责 SSL 表的数据库操作
+// SSLDao 基于 gorm 的 Store 实现,支持 SQLite、MySQL 和 PostgreSQL
 type SSLDao struct {
-	db *gorm.DB
+	db   *gorm.DB
+	keys *keyring.Keyring // 为空时私钥以明文保存
 }
 
-// NewSSLDao 创建一个新的 SSLDao 实例
-func NewSSLDao(path string) (*SSLDao, error) {
-	// 自动创建父级目录
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return nil, fmt.Errorf("create db directory failed: %w", err)
+var _ Store = (*SSLDao)(nil)
+
+// 支持的数据库驱动
+const (
+	DriverSQLite   = "sqlite"
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+)
+
+// NewSSLDao 打开 SQLite 数据库文件
+func NewSSLDao(path string, keys *keyring.Keyring) (*SSLDao, error) {
+	return Open(DriverSQLite, path, keys)
+}
+
+// Open 使用指定的驱动连接数据库,keys 不为空时私钥加密保存,已有的明文私钥会在启动时加密。
+// sqlite 的 dsn 为数据库文件路径,mysql 和 postgres 的 dsn 格式见各自的驱动
+func Open(driver, dsn string, keys *keyring.Keyring) (*SSLDao, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverSQLite, "":
+		// 自动创建父级目录
+		if err := os.MkdirAll(filepath.Dir(dsn), 0755); err != nil {
+			return nil, fmt.Errorf("create db directory failed: %w", err)
+		}
+		dialector = sqlite.Open(sqliteDSN(dsn))
+	case DriverMySQL:
+		dialector = mysql.Open(dsn)
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
 	}
 
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: newLogger()})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
-	// 自动迁移表结构
-	if err := db.AutoMigrate(&SSL{}, &Domain{}); err != nil {
+	// 按版本迁移表结构,数据库版本高于程序支持的版本时拒绝启动
+	if err := migrateTo(db, LatestSchemaVersion()); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	return &SSLDao{db: db}, nil
+	dao := &SSLDao{db: db, keys: keys}
+	if keys != nil {
+		n, err := dao.reencryptKeys(func(keyPEM string) bool {
+			return !keyring.IsEncrypted(keyPEM)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("加密已有的私钥失败: %w", err)
+		}
+		if n > 0 {
+			log.Printf("已加密 %d 个明文保存的私钥", n)
+		}
+	}
+	return dao, nil
+}
+
+// newLogger gorm 的默认 logger 直接写入标准输出,绕过了 log.SetOutput 设置的脱敏,
+// 这里改为通过 redact.Writer 写入标准错误
+func newLogger() logger.Interface {
+	return logger.New(log.New(redact.Writer(os.Stderr), "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+		// SQL 日志中只输出占位符,不输出证书、私钥等参数
+		ParameterizedQueries: true,
+	})
+}
+
+// sqliteDSN 为 SQLite 连接补充并发写入需要的参数:事务开始时即获取写锁,
+// 锁被占用时等待而不是立即返回 database is locked
+func sqliteDSN(dsn string) string {
+	params := []string{"_busy_timeout=5000", "_txlock=immediate"}
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if strings.Contains(dsn, key+"=") {
+			continue
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + param
+		} else {
+			dsn += "?" + param
+		}
+	}
+	return dsn
 }
 
 // GetSSLByID 通过 certId 获取 SSL 证书
@@ -41,10 +119,13 @@ func (dao *SSLDao) GetSSLByID(certId string) (*SSL, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := dao.decrypt(&ssl); err != nil {
+		return nil, err
+	}
 	return &ssl, nil
 }
 
-// GetSSLByID 通过 certId 获取 SSL 证书
+// GetSSLByName 通过可注册域名获取证书,私钥保持数据库中的形式,需要时调用 DecryptKey
 func (dao *SSLDao) GetSSLByName(name string) (*SSL, error) {
 	var ssl SSL
 	err := dao.db.Preload("Domains").Where("domain_name= ?", name).Find(&ssl).Error
@@ -54,16 +135,17 @@ func (dao *SSLDao) GetSSLByName(name string) (*SSL, error) {
 	return &ssl, nil
 }
 
-func (dao *SSLDao) GetSSLS() (*[]SSL, error) {
-	var ssl []SSL
-	err := dao.db.Preload("Domains").Find(&ssl).Error
+// ListSSLs 获取全部证书及绑定的域名,不读取私钥,用于列表和监控指标
+func (dao *SSLDao) ListSSLs() ([]SSL, error) {
+	var ssls []SSL
+	err := dao.db.Omit("key_pem").Preload("Domains").Order("id").Find(&ssls).Error
 	if err != nil {
 		return nil, err
 	}
-	return &ssl, nil
+	return ssls, nil
 }
 
-// GetSSLByCertID 通过 CertID 获取 SSL 证书
+// GetSSLByCertID 通过 CertID 获取 SSL 证书,私钥保持数据库中的形式,需要时调用 DecryptKey
 func (dao *SSLDao) GetSSLByCertID(certID string) (*SSL, error) {
 	var ssl SSL
 	err := dao.db.Preload("Domains").Where("cert_id = ?", certID).First(&ssl).Error
@@ -73,6 +155,11 @@ func (dao *SSLDao) GetSSLByCertID(certID string) (*SSL, error) {
 	return &ssl, nil
 }
 
+// DecryptKey 解密 GetSSLByName 或 GetSSLByCertID 返回的私钥
+func (dao *SSLDao) DecryptKey(ssl *SSL) error {
+	return dao.decrypt(ssl)
+}
+
 func (dao *SSLDao) GetDomains(domainName string) (int64, []string, error) {
 	var ssl SSL
 	var domainNames []string
@@ -90,25 +177,121 @@ func (dao *SSLDao) GetDomains(domainName string) (int64, []string, error) {
 	return ssl.NotAfter.Unix(), domainNames, nil
 }
 
+// SaveSSL 在一个事务中按 CertID 插入或更新证书,并将绑定的域名替换为 ssl.Domains,
+// 域名原来属于其他证书时直接转移到该证书
 func (dao *SSLDao) SaveSSL(ssl *SSL) error {
+	return dao.ReplaceSSL(nil, ssl)
+}
 
-	err := dao.DeleteSSL(ssl.CertID)
+// ReplaceSSL 在一个事务中记录并删除被替换的旧证书 old,再保存新证书 ssl,
+// 任意一步失败时数据库保持原样。old 为空或 ID 为 0 时等同于 SaveSSL
+func (dao *SSLDao) ReplaceSSL(old, ssl *SSL) error {
+	// 只在写入数据库时加密,调用方持有的仍然是明文
+	keyPEM, err := dao.encrypt(ssl.KeyPEM)
 	if err != nil {
 		return err
 	}
 
-	err = dao.db.Create(ssl).Error
+	var saved SSL
+	var domains []Domain
+	err = dao.db.Transaction(func(tx *gorm.DB) error {
+		if old != nil && old.ID != 0 {
+			if err := retireSSL(tx, old, ssl.CertID); err != nil {
+				return err
+			}
+			if err := deleteSSL(tx, old.CertID); err != nil {
+				return err
+			}
+		}
+		var err error
+		saved, domains, err = saveSSL(tx, ssl, keyPEM)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	ssl.Model = saved.Model
+	ssl.Domains = domains
 	return nil
 }
 
-// DeleteSSL 硬删除 SSL 证书及关联域名
+// saveSSL 按 CertID 插入或更新证书并替换绑定的域名,keyPEM 为加密后的私钥。
+// ssl.KeyPEM 为空或已加密时说明是从数据库读取后原样保存,不更新私钥,
+// 避免覆盖同时执行的 RotateKeys 重新加密的结果
+func saveSSL(tx *gorm.DB, ssl *SSL, keyPEM string) (SSL, []Domain, error) {
+	names := make([]string, 0, len(ssl.Domains))
+	for _, domain := range ssl.Domains {
+		names = append(names, domain.Name)
+	}
+
+	row := SSL{
+		DomainName: ssl.DomainName,
+		CertID:     ssl.CertID,
+		CertPEM:    ssl.CertPEM,
+		KeyPEM:     keyPEM,
+		NotAfter:   ssl.NotAfter,
+		SANs:       ssl.SANs,
+	}
+	columns := []string{"domain_name", "cert_pem", "not_after", "sans", "updated_at", "deleted_at"}
+	if ssl.KeyPEM != "" && !keyring.IsEncrypted(ssl.KeyPEM) {
+		columns = append(columns, "key_pem")
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cert_id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Omit("Domains").Create(&row).Error
+	if err != nil {
+		return SSL{}, nil, err
+	}
+	// 更新时部分数据库不会返回已有记录的 ID,重新查询
+	var saved SSL
+	if err := tx.Where("cert_id = ?", ssl.CertID).First(&saved).Error; err != nil {
+		return SSL{}, nil, err
+	}
+
+	// 解除不再绑定的域名
+	release := tx.Unscoped().Where("ssl_id = ?", saved.ID)
+	if len(names) > 0 {
+		release = release.Where("name NOT IN ?", names)
+	}
+	if err := release.Delete(&Domain{}).Error; err != nil {
+		return SSL{}, nil, err
+	}
+
+	// 按域名插入或转移到当前证书,不会违反域名的唯一约束
+	now := time.Now()
+	for _, name := range names {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "name"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"ssl_id":     saved.ID,
+				"updated_at": now,
+				"deleted_at": nil,
+			}),
+		}).Create(&Domain{Name: name, SSLID: saved.ID}).Error
+		if err != nil {
+			return SSL{}, nil, err
+		}
+	}
+	var domains []Domain
+	if err := tx.Where("ssl_id = ?", saved.ID).Order("id").Find(&domains).Error; err != nil {
+		return SSL{}, nil, err
+	}
+	return saved, domains, nil
+}
+
+// DeleteSSL 在一个事务中硬删除 SSL 证书及关联域名
 func (dao *SSLDao) DeleteSSL(certID string) error {
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		return deleteSSL(tx, certID)
+	})
+}
+
+// deleteSSL 硬删除 SSL 证书及关联域名
+func deleteSSL(tx *gorm.DB, certID string) error {
 	var ssl SSL
-	if err := dao.db.Unscoped().Where("cert_id = ?", certID).Find(&ssl).Error; err != nil {
+	if err := tx.Unscoped().Where("cert_id = ?", certID).Find(&ssl).Error; err != nil {
 		return err
 	}
 	if ssl.ID == 0 {
@@ -116,10 +299,169 @@ func (dao *SSLDao) DeleteSSL(certID string) error {
 	}
 
 	// 直接硬删除关联的域名
-	if err := dao.db.Unscoped().Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error; err != nil {
+	if err := tx.Unscoped().Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error; err != nil {
 		return err
 	}
 
 	// 直接硬删除 SSL 记录
-	return dao.db.Unscoped().Delete(&ssl).Error
+	return tx.Unscoped().Delete(&ssl).Error
+}
+
+// RetireSSL 记录被 replacedBy 替换下来的证书,等待之后从七牛云删除
+func (dao *SSLDao) RetireSSL(ssl *SSL, replacedBy string) error {
+	return retireSSL(dao.db, ssl, replacedBy)
+}
+
+func retireSSL(tx *gorm.DB, ssl *SSL, replacedBy string) error {
+	domains := make([]string, 0, len(ssl.Domains))
+	for _, domain := range ssl.Domains {
+		domains = append(domains, domain.Name)
+	}
+	return tx.Create(&RetiredSSL{
+		CertID:     ssl.CertID,
+		DomainName: ssl.DomainName,
+		ReplacedBy: replacedBy,
+		Domains:    domains,
+		RetiredAt:  time.Now(),
+	}).Error
+}
+
+// GetRetiredSSLs 获取所有等待删除的证书
+func (dao *SSLDao) GetRetiredSSLs() ([]RetiredSSL, error) {
+	var retired []RetiredSSL
+	err := dao.db.Order("retired_at").Find(&retired).Error
+	if err != nil {
+		return nil, err
+	}
+	return retired, nil
+}
+
+// DeleteRetiredSSL 七牛云上的证书删除后,硬删除对应的记录
+func (dao *SSLDao) DeleteRetiredSSL(certID string) error {
+	return dao.db.Unscoped().Where("cert_id = ?", certID).Delete(&RetiredSSL{}).Error
+}
+
+// GetDomainByName 通过域名获取绑定记录
+func (dao *SSLDao) GetDomainByName(name string) (*Domain, error) {
+	var domain Domain
+	err := dao.db.Where("name = ?", name).First(&domain).Error
+	if err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// GetBindFailure 获取域名的绑定失败记录,不存在时返回 ID 为 0 的记录
+func (dao *SSLDao) GetBindFailure(domain string) (*BindFailure, error) {
+	var failure BindFailure
+	err := dao.db.Where("domain = ?", domain).Find(&failure).Error
+	if err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// SaveBindFailure 保存或更新域名的绑定失败记录
+func (dao *SSLDao) SaveBindFailure(failure *BindFailure) error {
+	return dao.db.Save(failure).Error
+}
+
+// GetBindFailures 获取所有绑定失败的域名
+func (dao *SSLDao) GetBindFailures() ([]BindFailure, error) {
+	var failures []BindFailure
+	err := dao.db.Order("domain").Find(&failures).Error
+	if err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// ClearBindFailure 域名绑定成功后删除失败记录
+func (dao *SSLDao) ClearBindFailure(domain string) error {
+	return dao.db.Unscoped().Where("domain = ?", domain).Delete(&BindFailure{}).Error
+}
+
+// SaveRun 保存或更新同步记录
+func (dao *SSLDao) SaveRun(run *SyncRun) error {
+	return dao.db.Save(run).Error
+}
+
+// GetRuns 按开始时间倒序获取最近的同步记录
+func (dao *SSLDao) GetRuns(limit int) ([]SyncRun, error) {
+	var runs []SyncRun
+	err := dao.db.Order("started_at desc").Limit(limit).Find(&runs).Error
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// PruneRuns 硬删除开始时间早于 before 的同步记录,关联的审计日志保留
+func (dao *SSLDao) PruneRuns(before time.Time) (int64, error) {
+	result := dao.db.Unscoped().Where("started_at < ?", before).Delete(&SyncRun{})
+	return result.RowsAffected, result.Error
+}
+
+// AddAuditLog 写入一条审计日志
+func (dao *SSLDao) AddAuditLog(log *AuditLog) error {
+	return dao.db.Create(log).Error
+}
+
+// AcquireLock 获取或续期名为 name 的锁,锁已被其他 owner 持有且未过期时返回 false
+func (dao *SSLDao) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	// 续期自己持有的锁,或者接管已经过期的锁
+	result := dao.db.Model(&Lock{}).
+		Where("name = ? AND (owner = ? OR expires_at < ?)", name, owner, now).
+		Updates(map[string]any{"owner": owner, "expires_at": now.Add(ttl)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// 锁不存在时插入,与其他进程同时插入时只有一个会成功
+	result = dao.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Lock{Name: name, Owner: owner, ExpiresAt: now.Add(ttl)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ReleaseLock 释放自己持有的锁,锁已经被其他 owner 接管时不做任何事
+func (dao *SSLDao) ReleaseLock(name, owner string) error {
+	return dao.db.Where("name = ? AND owner = ?", name, owner).Delete(&Lock{}).Error
+}
+
+// GetAuditLogs 按时间倒序查询审计日志
+func (dao *SSLDao) GetAuditLogs(query AuditQuery) ([]AuditLog, error) {
+	db := dao.db.Model(&AuditLog{})
+	if query.RunID != 0 {
+		db = db.Where("run_id = ?", query.RunID)
+	}
+	if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.CertID != "" {
+		db = db.Where("cert_id = ?", query.CertID)
+	}
+	if query.Domain != "" {
+		db = db.Where("domain = ?", query.Domain)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var logs []AuditLog
+	err := db.Order("created_at desc, id desc").Limit(limit).Find(&logs).Error
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
 }
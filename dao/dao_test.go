@@ -0,0 +1,470 @@
+package dao
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/keyring"
+)
+
+// forEachStore 对 SQLite 和内存存储分别运行同一组测试
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := Open(DriverSQLite, filepath.Join(t.TempDir(), "ssl.db"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+}
+
+func newSSL(certID string, domains ...string) *SSL {
+	ssl := &SSL{
+		DomainName: "example.com",
+		CertID:     certID,
+		CertPEM:    "cert-" + certID,
+		KeyPEM:     "key-" + certID,
+		NotAfter:   time.Now().Add(90 * 24 * time.Hour),
+		SANs:       []string{"example.com", "*.example.com"},
+	}
+	for _, domain := range domains {
+		ssl.Domains = append(ssl.Domains, Domain{Name: domain})
+	}
+	return ssl
+}
+
+func domainNames(ssl *SSL) []string {
+	names := make([]string, 0, len(ssl.Domains))
+	for _, domain := range ssl.Domains {
+		names = append(names, domain.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestSaveSSLMovesDomain(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.SaveSSL(newSSL("a", "a.example.com", "www.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		b := newSSL("b", "b.example.com", "www.example.com")
+		if err := store.SaveSSL(b); err != nil {
+			t.Fatal(err)
+		}
+		if got := domainNames(b); !slices.Equal(got, []string{"b.example.com", "www.example.com"}) {
+			t.Fatalf("b domains = %v", got)
+		}
+
+		a, err := store.GetSSLByCertID("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := domainNames(a); !slices.Equal(got, []string{"a.example.com"}) {
+			t.Fatalf("a domains = %v, want www.example.com moved to b", got)
+		}
+		domain, err := store.GetDomainByName("www.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if domain.SSLID != b.ID {
+			t.Fatalf("www.example.com ssl_id = %d, want %d", domain.SSLID, b.ID)
+		}
+
+		// 再次保存同一个 CertID 时更新原记录而不是插入新记录
+		again := newSSL("b", "b.example.com")
+		if err := store.SaveSSL(again); err != nil {
+			t.Fatal(err)
+		}
+		if again.ID != b.ID {
+			t.Fatalf("upsert changed id from %d to %d", b.ID, again.ID)
+		}
+		if _, err := store.GetDomainByName("www.example.com"); err != ErrNotFound {
+			t.Fatalf("released domain err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestSaveSSLConcurrent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		const workers = 8
+		const rounds = 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*rounds)
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// 两张证书交替抢占 shared.example.com
+				certID := []string{"a", "b"}[w%2]
+				for i := range rounds {
+					ssl := newSSL(certID, "shared.example.com", fmt.Sprintf("%s%d.example.com", certID, i))
+					if err := store.SaveSSL(ssl); err != nil {
+						errs <- err
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		ssls, err := store.ListSSLs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ssls) != 2 {
+			t.Fatalf("got %d ssl rows, want 2", len(ssls))
+		}
+		// 每个域名只属于一张证书
+		owners := make(map[string]string)
+		for _, ssl := range ssls {
+			for _, domain := range ssl.Domains {
+				if owner, ok := owners[domain.Name]; ok {
+					t.Fatalf("%s bound to both %s and %s", domain.Name, owner, ssl.CertID)
+				}
+				owners[domain.Name] = ssl.CertID
+			}
+		}
+		if _, ok := owners["shared.example.com"]; !ok {
+			t.Fatal("shared.example.com is not bound to any certificate")
+		}
+	})
+}
+
+func TestReplaceSSL(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		old := newSSL("old", "www.example.com")
+		if err := store.SaveSSL(old); err != nil {
+			t.Fatal(err)
+		}
+
+		ssl := newSSL("new")
+		if err := store.ReplaceSSL(old, ssl); err != nil {
+			t.Fatal(err)
+		}
+		if ssl.ID == 0 {
+			t.Fatal("new certificate was not saved")
+		}
+		if _, err := store.GetSSLByCertID("old"); err != ErrNotFound {
+			t.Fatalf("old certificate err = %v, want ErrNotFound", err)
+		}
+		retired, err := store.GetRetiredSSLs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(retired) != 1 || retired[0].CertID != "old" || retired[0].ReplacedBy != "new" {
+			t.Fatalf("retired = %+v", retired)
+		}
+		if !slices.Equal(retired[0].Domains, []string{"www.example.com"}) {
+			t.Fatalf("retired domains = %v", retired[0].Domains)
+		}
+	})
+}
+
+func TestReplaceSSLRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		old := newSSL("old", "www.example.com")
+		if err := store.SaveSSL(old); err != nil {
+			t.Fatal(err)
+		}
+		// 已经存在同一 CertID 的替换记录,记录旧证书时违反唯一约束
+		if err := store.RetireSSL(old, "other"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.ReplaceSSL(old, newSSL("new")); err == nil {
+			t.Fatal("ReplaceSSL succeeded, want unique constraint error")
+		}
+		stored, err := store.GetSSLByCertID("old")
+		if err != nil {
+			t.Fatalf("old certificate lost after failed replace: %v", err)
+		}
+		if got := domainNames(stored); !slices.Equal(got, []string{"www.example.com"}) {
+			t.Fatalf("old domains = %v", got)
+		}
+		if _, err := store.GetSSLByCertID("new"); err != ErrNotFound {
+			t.Fatalf("new certificate err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		acquire := func(owner string, ttl time.Duration) bool {
+			t.Helper()
+			ok, err := store.AcquireLock("sync", owner, ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ok
+		}
+
+		if !acquire("daemon", time.Minute) {
+			t.Fatal("daemon could not acquire free lock")
+		}
+		if acquire("cli", time.Minute) {
+			t.Fatal("cli acquired lock held by daemon")
+		}
+		// 持有者可以续期
+		if !acquire("daemon", time.Minute) {
+			t.Fatal("daemon could not renew its lock")
+		}
+		// 只能释放自己持有的锁
+		if err := store.ReleaseLock("sync", "cli"); err != nil {
+			t.Fatal(err)
+		}
+		if acquire("cli", time.Minute) {
+			t.Fatal("lock released by non-owner")
+		}
+		if err := store.ReleaseLock("sync", "daemon"); err != nil {
+			t.Fatal(err)
+		}
+		if !acquire("cli", -time.Second) {
+			t.Fatal("cli could not acquire released lock")
+		}
+		// 过期的锁可以被接管
+		if !acquire("daemon", time.Minute) {
+			t.Fatal("daemon could not take over expired lock")
+		}
+	})
+}
+
+func TestKeysDecryptedOnDemand(t *testing.T) {
+	entry, err := keyring.Generate("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Parse(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := Open(DriverSQLite, filepath.Join(t.TempDir(), "ssl.db"), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveSSL(newSSL("a", "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	ssls, err := store.ListSSLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ssls) != 1 || ssls[0].KeyPEM != "" || len(ssls[0].Domains) != 1 {
+		t.Fatalf("ListSSLs = %+v, want one certificate without key", ssls)
+	}
+
+	// 读取后原样保存不会重复加密
+	ssl, err := store.GetSSLByCertID("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.IsEncrypted(ssl.KeyPEM) {
+		t.Fatalf("KeyPEM = %q, want ciphertext", ssl.KeyPEM)
+	}
+	if err := store.SaveSSL(ssl); err != nil {
+		t.Fatal(err)
+	}
+	ssl, err = store.GetSSLByCertID("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DecryptKey(ssl); err != nil {
+		t.Fatal(err)
+	}
+	if ssl.KeyPEM != "key-a" {
+		t.Fatalf("decrypted KeyPEM = %q, want key-a", ssl.KeyPEM)
+	}
+}
+
+func TestRotateKeysWithConcurrentSave(t *testing.T) {
+	entry := func(id string) string {
+		t.Helper()
+		key, err := keyring.Generate(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	k1, k2 := entry("k1"), entry("k2")
+	oldKeys, err := keyring.Parse(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeys, err := keyring.Parse(k2 + "," + k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 常驻服务仍然使用旧主密钥,一次性命令使用新主密钥轮换
+	path := filepath.Join(t.TempDir(), "ssl.db")
+	daemon, err := Open(DriverSQLite, path, oldKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.SaveSSL(newSSL("a", "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := daemon.GetSSLByCertID("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := Open(DriverSQLite, path, newKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			ssl, err := daemon.GetSSLByCertID("a")
+			if err == nil {
+				err = daemon.SaveSSL(ssl)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}
+	}()
+	if _, err := cli.RotateKeys(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	// 轮换之前读取的记录原样写回也不会覆盖新主密钥加密的私钥
+	if err := daemon.SaveSSL(stale); err != nil {
+		t.Fatal(err)
+	}
+
+	ssl, err := cli.GetSSLByCertID("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := keyring.KeyID(ssl.KeyPEM); id != "k2" {
+		t.Fatalf("key encrypted with %q after rotation, want k2", id)
+	}
+	if err := cli.DecryptKey(ssl); err != nil {
+		t.Fatal(err)
+	}
+	if ssl.KeyPEM != "key-a" {
+		t.Fatalf("decrypted KeyPEM = %q, want key-a", ssl.KeyPEM)
+	}
+}
+
+func TestGetAuditLogs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		add := func(runID uint, action, certID, domain string) {
+			t.Helper()
+			err := store.AddAuditLog(&AuditLog{RunID: runID, Action: action, CertID: certID, Domain: domain})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		add(1, AuditActionObtain, "a", "example.com")
+		add(1, AuditActionBind, "a", "www.example.com")
+		time.Sleep(10 * time.Millisecond)
+		since := time.Now()
+		time.Sleep(10 * time.Millisecond)
+		add(2, AuditActionBind, "b", "www.example.com")
+		add(2, AuditActionBind, "b", "img.example.com")
+		add(0, AuditActionDelete, "a", "example.com")
+
+		tests := []struct {
+			name  string
+			query AuditQuery
+			want  []string // 按时间倒序的 action/certID/domain
+		}{
+			{"all", AuditQuery{}, []string{
+				"delete/a/example.com", "bind/b/img.example.com", "bind/b/www.example.com",
+				"bind/a/www.example.com", "obtain/a/example.com",
+			}},
+			{"run", AuditQuery{RunID: 1}, []string{"bind/a/www.example.com", "obtain/a/example.com"}},
+			{"action", AuditQuery{Action: AuditActionBind}, []string{
+				"bind/b/img.example.com", "bind/b/www.example.com", "bind/a/www.example.com",
+			}},
+			{"cert", AuditQuery{CertID: "a"}, []string{
+				"delete/a/example.com", "bind/a/www.example.com", "obtain/a/example.com",
+			}},
+			{"domain", AuditQuery{Domain: "www.example.com"}, []string{"bind/b/www.example.com", "bind/a/www.example.com"}},
+			{"since", AuditQuery{Since: since}, []string{
+				"delete/a/example.com", "bind/b/img.example.com", "bind/b/www.example.com",
+			}},
+			{"combined", AuditQuery{Action: AuditActionBind, CertID: "b", Since: since}, []string{
+				"bind/b/img.example.com", "bind/b/www.example.com",
+			}},
+			{"limit", AuditQuery{Limit: 2}, []string{"delete/a/example.com", "bind/b/img.example.com"}},
+			{"no match", AuditQuery{Domain: "example.org"}, nil},
+		}
+		for _, tt := range tests {
+			logs, err := store.GetAuditLogs(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, log := range logs {
+				got = append(got, log.Action+"/"+log.CertID+"/"+log.Domain)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: logs = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestGetRunsAndPrune(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now()
+		// 保存顺序与开始时间不同,结果按开始时间倒序
+		for _, days := range []int{3, 1, 40, 2} {
+			run := &SyncRun{Kind: "sync", Target: fmt.Sprint(days), StartedAt: now.AddDate(0, 0, -days)}
+			if err := store.SaveRun(run); err != nil {
+				t.Fatal(err)
+			}
+		}
+		targets := func(limit int) []string {
+			t.Helper()
+			runs, err := store.GetRuns(limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, run := range runs {
+				got = append(got, run.Target)
+			}
+			return got
+		}
+		if got := targets(10); !slices.Equal(got, []string{"1", "2", "3", "40"}) {
+			t.Fatalf("runs = %v", got)
+		}
+		if got := targets(2); !slices.Equal(got, []string{"1", "2"}) {
+			t.Fatalf("runs with limit 2 = %v", got)
+		}
+
+		n, err := store.PruneRuns(now.AddDate(0, 0, -30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("pruned %d runs, want 1", n)
+		}
+		if got := targets(10); !slices.Equal(got, []string{"1", "2", "3"}) {
+			t.Fatalf("runs after prune = %v", got)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package dao
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore 保存在内存中的 Store 实现,进程退出后数据丢失,用于测试和临时运行
+type MemoryStore struct {
+	mu       sync.Mutex
+	nextID   uint
+	ssls     map[string]*SSL // CertID -> 证书,Domains 以 domains 为准
+	domains  map[string]Domain
+	retired  map[string]RetiredSSL
+	failures map[string]BindFailure
+	runs     map[uint]SyncRun
+	logs     []AuditLog
+	locks    map[string]Lock
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore 创建一个空的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		ssls:     make(map[string]*SSL),
+		domains:  make(map[string]Domain),
+		retired:  make(map[string]RetiredSSL),
+		failures: make(map[string]BindFailure),
+		runs:     make(map[uint]SyncRun),
+		locks:    make(map[string]Lock),
+	}
+}
+
+// model 生成新记录的 gorm.Model,调用方需要持有锁
+func (m *MemoryStore) model() gorm.Model {
+	m.nextID++
+	now := time.Now()
+	return gorm.Model{ID: m.nextID, CreatedAt: now, UpdatedAt: now}
+}
+
+// load 返回证书的拷贝并填充绑定的域名,调用方需要持有锁
+func (m *MemoryStore) load(ssl *SSL) *SSL {
+	out := *ssl
+	out.SANs = slices.Clone(ssl.SANs)
+	out.Domains = nil
+	for _, domain := range m.domains {
+		if domain.SSLID == ssl.ID {
+			out.Domains = append(out.Domains, domain)
+		}
+	}
+	sort.Slice(out.Domains, func(i, j int) bool {
+		return out.Domains[i].ID < out.Domains[j].ID
+	})
+	return &out
+}
+
+func (m *MemoryStore) GetSSLByName(name string) (*SSL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ssl := range m.ssls {
+		if ssl.DomainName == name {
+			return m.load(ssl), nil
+		}
+	}
+	return &SSL{}, nil
+}
+
+func (m *MemoryStore) GetSSLByCertID(certID string) (*SSL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ssl, ok := m.ssls[certID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.load(ssl), nil
+}
+
+// DecryptKey 内存中的私钥不加密
+func (m *MemoryStore) DecryptKey(ssl *SSL) error {
+	return nil
+}
+
+func (m *MemoryStore) ListSSLs() ([]SSL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ssls := make([]SSL, 0, len(m.ssls))
+	for _, ssl := range m.ssls {
+		out := m.load(ssl)
+		out.KeyPEM = ""
+		ssls = append(ssls, *out)
+	}
+	sort.Slice(ssls, func(i, j int) bool {
+		return ssls[i].ID < ssls[j].ID
+	})
+	return ssls, nil
+}
+
+// SaveSSL 与 SSLDao 相同,按 CertID 插入或更新证书,并将绑定的域名替换为 ssl.Domains
+func (m *MemoryStore) SaveSSL(ssl *SSL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveSSL(ssl)
+	return nil
+}
+
+// ReplaceSSL 与 SSLDao 相同,记录并删除旧证书后保存新证书,整个过程持有锁
+func (m *MemoryStore) ReplaceSSL(old, ssl *SSL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old != nil && old.ID != 0 {
+		if err := m.retireSSL(old, ssl.CertID); err != nil {
+			return err
+		}
+		m.deleteSSL(old.CertID)
+	}
+	m.saveSSL(ssl)
+	return nil
+}
+
+// saveSSL 插入或更新证书并替换绑定的域名,调用方需要持有锁
+func (m *MemoryStore) saveSSL(ssl *SSL) {
+	stored := *ssl
+	stored.SANs = slices.Clone(ssl.SANs)
+	stored.Domains = nil
+	if existing, ok := m.ssls[ssl.CertID]; ok {
+		stored.Model = existing.Model
+		stored.UpdatedAt = time.Now()
+		// 与 SSLDao 相同,从 ListSSLs 读取的证书没有私钥,保存时保留原来的私钥
+		if stored.KeyPEM == "" {
+			stored.KeyPEM = existing.KeyPEM
+		}
+	} else {
+		stored.Model = m.model()
+	}
+	m.ssls[ssl.CertID] = &stored
+
+	names := make(map[string]struct{}, len(ssl.Domains))
+	for _, domain := range ssl.Domains {
+		names[domain.Name] = struct{}{}
+	}
+	// 解除不再绑定的域名
+	for name, domain := range m.domains {
+		if _, ok := names[name]; !ok && domain.SSLID == stored.ID {
+			delete(m.domains, name)
+		}
+	}
+	// 插入或转移到当前证书
+	for name := range names {
+		domain, ok := m.domains[name]
+		if ok {
+			domain.UpdatedAt = time.Now()
+		} else {
+			domain = Domain{Model: m.model(), Name: name}
+		}
+		domain.SSLID = stored.ID
+		m.domains[name] = domain
+	}
+
+	ssl.Model = stored.Model
+	ssl.Domains = m.load(&stored).Domains
+}
+
+func (m *MemoryStore) DeleteSSL(certID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteSSL(certID)
+	return nil
+}
+
+// deleteSSL 删除证书及其绑定的域名,调用方需要持有锁
+func (m *MemoryStore) deleteSSL(certID string) {
+	ssl, ok := m.ssls[certID]
+	if !ok {
+		return
+	}
+	for name, domain := range m.domains {
+		if domain.SSLID == ssl.ID {
+			delete(m.domains, name)
+		}
+	}
+	delete(m.ssls, certID)
+}
+
+// RotateKeys 内存中的私钥不加密
+func (m *MemoryStore) RotateKeys() (int, error) {
+	return 0, errors.New("内存存储不加密私钥")
+}
+
+func (m *MemoryStore) RetireSSL(ssl *SSL, replacedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retireSSL(ssl, replacedBy)
+}
+
+// retireSSL 记录被替换的证书,调用方需要持有锁
+func (m *MemoryStore) retireSSL(ssl *SSL, replacedBy string) error {
+	if _, ok := m.retired[ssl.CertID]; ok {
+		return errors.New("UNIQUE constraint failed: retired_ssls.cert_id")
+	}
+	domains := make([]string, 0, len(ssl.Domains))
+	for _, domain := range ssl.Domains {
+		domains = append(domains, domain.Name)
+	}
+	m.retired[ssl.CertID] = RetiredSSL{
+		Model:      m.model(),
+		CertID:     ssl.CertID,
+		DomainName: ssl.DomainName,
+		ReplacedBy: replacedBy,
+		Domains:    domains,
+		RetiredAt:  time.Now(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetRetiredSSLs() ([]RetiredSSL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	retired := make([]RetiredSSL, 0, len(m.retired))
+	for _, r := range m.retired {
+		r.Domains = slices.Clone(r.Domains)
+		retired = append(retired, r)
+	}
+	sort.Slice(retired, func(i, j int) bool {
+		return retired[i].RetiredAt.Before(retired[j].RetiredAt)
+	})
+	return retired, nil
+}
+
+func (m *MemoryStore) DeleteRetiredSSL(certID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.retired, certID)
+	return nil
+}
+
+func (m *MemoryStore) GetDomainByName(name string) (*Domain, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	domain, ok := m.domains[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &domain, nil
+}
+
+func (m *MemoryStore) GetBindFailure(domain string) (*BindFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failure := m.failures[domain]
+	return &failure, nil
+}
+
+func (m *MemoryStore) SaveBindFailure(failure *BindFailure) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if failure.ID == 0 {
+		failure.Model = m.model()
+	} else {
+		failure.UpdatedAt = time.Now()
+	}
+	m.failures[failure.Domain] = *failure
+	return nil
+}
+
+func (m *MemoryStore) GetBindFailures() ([]BindFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := make([]BindFailure, 0, len(m.failures))
+	for _, failure := range m.failures {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Domain < failures[j].Domain
+	})
+	return failures, nil
+}
+
+func (m *MemoryStore) ClearBindFailure(domain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, domain)
+	return nil
+}
+
+// AcquireLock 内存存储只在当前进程内有效
+func (m *MemoryStore) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if lock, ok := m.locks[name]; ok && lock.Owner != owner && !lock.ExpiresAt.Before(now) {
+		return false, nil
+	}
+	m.locks[name] = Lock{Name: name, Owner: owner, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) ReleaseLock(name, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lock, ok := m.locks[name]; ok && lock.Owner == owner {
+		delete(m.locks, name)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveRun(run *SyncRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run.ID == 0 {
+		run.Model = m.model()
+	} else {
+		run.UpdatedAt = time.Now()
+	}
+	m.runs[run.ID] = *run
+	return nil
+}
+
+func (m *MemoryStore) GetRuns(limit int) ([]SyncRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]SyncRun, 0, len(m.runs))
+	for _, run := range m.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (m *MemoryStore) PruneRuns(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, run := range m.runs {
+		if run.StartedAt.Before(before) {
+			delete(m.runs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) AddAuditLog(log *AuditLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	log.Model = m.model()
+	m.logs = append(m.logs, *log)
+	return nil
+}
+
+func (m *MemoryStore) GetAuditLogs(query AuditQuery) ([]AuditLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var logs []AuditLog
+	// 按时间倒序,即从后向前遍历
+	for i := len(m.logs) - 1; i >= 0 && len(logs) < limit; i-- {
+		log := m.logs[i]
+		if query.RunID != 0 && log.RunID != query.RunID ||
+			query.Action != "" && log.Action != query.Action ||
+			query.CertID != "" && log.CertID != query.CertID ||
+			query.Domain != "" && log.Domain != query.Domain ||
+			!query.Since.IsZero() && log.CreatedAt.Before(query.Since) {
+			continue
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
@@ -0,0 +1,120 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaVersion 已经执行的迁移,当前版本为其中最大的 Version
+type SchemaVersion struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// migration 一次数据库结构变更,up 和 down 在同一个事务中与版本记录一起执行。
+// 迁移中只能使用迁移自己定义的结构体快照,不能引用会随版本变化的模型。
+// down 为空表示无法回滚,例如回滚会删除证书和私钥的迁移
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+var (
+	// ErrSchemaTooNew 数据库由更新版本的程序迁移过,当前程序无法安全使用
+	ErrSchemaTooNew = errors.New("数据库结构版本高于程序支持的版本,请升级程序")
+	// ErrIrreversible 目标版本之后存在无法回滚的迁移
+	ErrIrreversible = errors.New("迁移无法回滚")
+)
+
+// LatestSchemaVersion 当前程序支持的数据库结构版本
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion 返回数据库当前的结构版本,没有执行过迁移时为 0
+func (dao *SSLDao) SchemaVersion() (int, error) {
+	return schemaVersion(dao.db)
+}
+
+// MigrateTo 将数据库迁移到指定版本,低于当前版本时依次执行 down
+func (dao *SSLDao) MigrateTo(target int) error {
+	return migrateTo(dao.db, target)
+}
+
+func schemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// migrateTo 从当前版本逐个执行迁移直到 target
+func migrateTo(db *gorm.DB, target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("目标版本 %d 不存在,支持的版本为 0-%d", target, LatestSchemaVersion())
+	}
+	current, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("获取数据库结构版本失败: %w", err)
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("%w: 数据库版本 %d,程序支持的版本 %d", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	// 回滚前确认每一步都可以回滚,避免只回滚了一部分
+	for _, m := range migrations {
+		if m.version > target && m.version <= current && m.down == nil {
+			return fmt.Errorf("%w: 版本 %d(%s)", ErrIrreversible, m.version, m.name)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("执行迁移 %d(%s) 失败: %w", m.version, m.name, err)
+		}
+		log.Printf("数据库已迁移到版本 %d(%s)", m.version, m.name)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{}, m.version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("回滚迁移 %d(%s) 失败: %w", m.version, m.name, err)
+		}
+		log.Printf("数据库已回滚版本 %d(%s)", m.version, m.name)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package dao
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T, path string) *SSLDao {
+	t.Helper()
+	store, err := Open(DriverSQLite, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMigrateInitialIsIrreversible(t *testing.T) {
+	store := openTestDB(t, filepath.Join(t.TempDir(), "ssl.db"))
+	if err := store.SaveSSL(newSSL("a", "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.MigrateTo(0)
+	if !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("MigrateTo(0) err = %v, want ErrIrreversible", err)
+	}
+	version, err := store.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Fatalf("version = %d, want %d", version, LatestSchemaVersion())
+	}
+	if _, err := store.GetSSLByCertID("a"); err != nil {
+		t.Fatalf("certificate lost after refused rollback: %v", err)
+	}
+}
+
+// noteSSL 测试用的迁移,为 ssls 表增加一列
+type noteSSL struct {
+	Note string
+}
+
+func (noteSSL) TableName() string { return "ssls" }
+
+func TestMigrateUpAndDown(t *testing.T) {
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	latest := LatestSchemaVersion()
+	next := latest + 1
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{
+		version: next,
+		name:    "ssl note",
+		up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&noteSSL{}, "Note")
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&noteSSL{}, "Note")
+		},
+	})
+
+	store := openTestDB(t, filepath.Join(t.TempDir(), "ssl.db"))
+	if version, _ := store.SchemaVersion(); version != next {
+		t.Fatalf("version after open = %d, want %d", version, next)
+	}
+	if !store.db.Migrator().HasColumn(&noteSSL{}, "Note") {
+		t.Fatal("up did not add column")
+	}
+
+	if err := store.MigrateTo(latest); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := store.SchemaVersion(); version != latest {
+		t.Fatalf("version after down = %d, want %d", version, latest)
+	}
+	if store.db.Migrator().HasColumn(&noteSSL{}, "Note") {
+		t.Fatal("down did not drop column")
+	}
+
+	// 需要回滚的迁移中有无法回滚的版本时直接拒绝,不会只回滚一部分
+	if err := store.MigrateTo(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateTo(0); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("MigrateTo(0) err = %v, want ErrIrreversible", err)
+	}
+	if version, _ := store.SchemaVersion(); version != next {
+		t.Fatalf("version after refused rollback = %d, want %d", version, next)
+	}
+}
+
+func TestOpenRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssl.db")
+	store := openTestDB(t, path)
+	err := store.db.Create(&SchemaVersion{Version: LatestSchemaVersion() + 1, Name: "future", AppliedAt: time.Now()}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(DriverSQLite, path, nil); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Open err = %v, want ErrSchemaTooNew", err)
+	}
+}
@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations 按版本从小到大排列,已经发布的迁移不能修改,结构变更时追加新的版本
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial",
+		// 之前由 AutoMigrate 创建的数据库同样从这里开始,已有的表只会补充缺少的列
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v1SSL{}, &v1Domain{}, &v1RetiredSSL{}, &v1BindFailure{}, &v1SyncRun{}, &v1AuditLog{})
+		},
+		// 回滚会删除全部证书和私钥,不允许回滚
+		down: nil,
+	},
+	{
+		version: 2,
+		name:    "locks",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v2Lock{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v2Lock{})
+		},
+	},
+}
+
+// 版本 1 的表结构快照
+
+type v1SSL struct {
+	gorm.Model
+	DomainName string `gorm:"type:varchar(255);not null"`
+	CertID     string `gorm:"size:255;unique;not null"`
+	CertPEM    string
+	KeyPEM     string
+	NotAfter   time.Time
+	SANs       []string   `gorm:"column:sans;serializer:json"`
+	Domains    []v1Domain `gorm:"foreignKey:SSLID"`
+}
+
+func (v1SSL) TableName() string { return "ssls" }
+
+type v1Domain struct {
+	gorm.Model
+	Name  string `gorm:"size:255;unique;not null"`
+	SSLID uint
+}
+
+func (v1Domain) TableName() string { return "domains" }
+
+type v1RetiredSSL struct {
+	gorm.Model
+	CertID     string `gorm:"size:255;unique;not null"`
+	DomainName string `gorm:"type:varchar(255);not null"`
+	ReplacedBy string
+	Domains    []string `gorm:"serializer:json"`
+	RetiredAt  time.Time
+}
+
+func (v1RetiredSSL) TableName() string { return "retired_ssls" }
+
+type v1BindFailure struct {
+	gorm.Model
+	Domain    string `gorm:"size:255;unique;not null"`
+	CertID    string
+	Attempts  int
+	LastError string
+	NextRetry time.Time
+}
+
+func (v1BindFailure) TableName() string { return "bind_failures" }
+
+type v1SyncRun struct {
+	gorm.Model
+	Kind       string
+	Target     string
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt time.Time
+	Outcome    string
+	Error      string
+	Succeeded  int
+	Failed     int
+}
+
+func (v1SyncRun) TableName() string { return "sync_runs" }
+
+type v1AuditLog struct {
+	gorm.Model
+	RunID   uint   `gorm:"index"`
+	Action  string `gorm:"size:32;index"`
+	CertID  string `gorm:"size:255;index"`
+	Domain  string `gorm:"size:255;index"`
+	Message string
+}
+
+func (v1AuditLog) TableName() string { return "audit_logs" }
+
+// 版本 2 的表结构快照
+
+type v2Lock struct {
+	Name      string `gorm:"size:255;primaryKey"`
+	Owner     string `gorm:"size:255;not null"`
+	ExpiresAt time.Time
+}
+
+func (v2Lock) TableName() string { return "locks" }
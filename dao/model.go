@@ -9,16 +9,94 @@ import (
 type SSL struct {
 	gorm.Model
 	DomainName string `gorm:"type:varchar(255);not null"`
-	CertID     string `gorm:"unique;not null"` // 证书 ID
+	CertID     string `gorm:"size:255;unique;not null"` // 证书 ID
 	CertPEM    string
 	KeyPEM     string
 	NotAfter   time.Time
-	Domains    []Domain `gorm:"foreignKey:SSLID"` // 关联 Domain
+	SANs       []string `gorm:"column:sans;serializer:json"` // 证书覆盖的全部名称
+	Domains    []Domain `gorm:"foreignKey:SSLID"`            // 关联 Domain
 }
 
 // Domain 域名表
 type Domain struct {
 	gorm.Model
-	Name  string `gorm:"unique;not null"` // 域名
+	Name  string `gorm:"size:255;unique;not null"` // 域名
 	SSLID uint   // 关联的 SSL 证书 ID
 }
+
+// RetiredSSL 被新证书替换下来、等待从七牛云删除的证书
+type RetiredSSL struct {
+	gorm.Model
+	CertID     string   `gorm:"size:255;unique;not null"` // 被替换的证书 ID
+	DomainName string   `gorm:"type:varchar(255);not null"`
+	ReplacedBy string   // 替换它的证书 ID
+	Domains    []string `gorm:"serializer:json"` // 替换时绑定在该证书上的域名
+	RetiredAt  time.Time
+}
+
+// BindFailure 绑定证书失败的域名,按照 NextRetry 退避重试
+type BindFailure struct {
+	gorm.Model
+	Domain    string `gorm:"size:255;unique;not null"` // 域名
+	CertID    string // 最近一次尝试绑定的证书 ID
+	Attempts  int    // 连续失败的次数
+	LastError string // 最近一次失败的原因
+	NextRetry time.Time
+}
+
+// SyncRun 每一次同步或手动续期的记录
+type SyncRun struct {
+	gorm.Model
+	Kind       string    // sync 或 renew
+	Target     string    // 手动续期的可注册域名
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt time.Time
+	Outcome    string // 见 RunOutcome 常量,未结束时为空
+	Error      string
+	Succeeded  int // 绑定成功的域名数量
+	Failed     int // 处理失败的域名数量
+}
+
+// 同步结果
+const (
+	RunOutcomeSuccess = "success" // 全部成功
+	RunOutcomePartial = "partial" // 部分域名失败
+	RunOutcomeFailed  = "failed"  // 同步在处理域名之前失败
+)
+
+// AuditLog 证书和域名的每一次变更及错误
+type AuditLog struct {
+	gorm.Model
+	RunID   uint   `gorm:"index"`         // 所属的 SyncRun,命令行或管理接口的操作为 0
+	Action  string `gorm:"size:32;index"` // 见 AuditAction 常量
+	CertID  string `gorm:"size:255;index"`
+	Domain  string `gorm:"size:255;index"` // 域名或可注册域名
+	Message string
+}
+
+// 审计日志的操作类型
+const (
+	AuditActionObtain = "obtain" // 申请证书
+	AuditActionUpload = "upload" // 上传证书到七牛云
+	AuditActionBind   = "bind"   // 域名绑定证书
+	AuditActionDelete = "delete" // 删除证书
+	AuditActionError  = "error"  // 处理失败
+)
+
+// Lock 跨进程的租约锁,常驻服务和一次性命令通过它避免同时修改证书,
+// 持有者在 ExpiresAt 之前需要续期,进程崩溃后锁在过期后自动失效
+type Lock struct {
+	Name      string `gorm:"size:255;primaryKey"`
+	Owner     string `gorm:"size:255;not null"`
+	ExpiresAt time.Time
+}
+
+// AuditQuery 审计日志的查询条件,字段为空时不过滤
+type AuditQuery struct {
+	RunID  uint
+	Action string
+	CertID string
+	Domain string
+	Since  time.Time
+	Limit  int // 默认 100
+}
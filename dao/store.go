@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound 查询的记录不存在,所有 Store 实现在按唯一键查询不到时都返回该错误
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// Store 证书及同步记录的存储,QiniuSSL 只依赖该接口
+type Store interface {
+	// GetSSLByName 通过可注册域名获取证书,不存在时返回 ID 为 0 的记录。
+	// 私钥保持保存时的形式,原样传给 SaveSSL 不会重复加密,使用私钥前需要调用 DecryptKey
+	GetSSLByName(name string) (*SSL, error)
+	// GetSSLByCertID 通过 CertID 获取证书,不存在时返回 ErrNotFound,私钥与 GetSSLByName 相同
+	GetSSLByCertID(certID string) (*SSL, error)
+	// DecryptKey 解密证书的私钥
+	DecryptKey(ssl *SSL) error
+	// ListSSLs 获取全部证书及绑定的域名,不包含私钥
+	ListSSLs() ([]SSL, error)
+	// SaveSSL 保存证书及其绑定的域名,域名原来属于其他证书时转移到该证书
+	SaveSSL(ssl *SSL) error
+	// ReplaceSSL 在一个事务中记录并删除被替换的旧证书,再保存新证书,old 为空时等同于 SaveSSL
+	ReplaceSSL(old, ssl *SSL) error
+	DeleteSSL(certID string) error
+	// RotateKeys 使用当前主密钥重新加密私钥
+	RotateKeys() (int, error)
+
+	RetireSSL(ssl *SSL, replacedBy string) error
+	GetRetiredSSLs() ([]RetiredSSL, error)
+	DeleteRetiredSSL(certID string) error
+	// GetDomainByName 获取域名的绑定记录,不存在时返回 ErrNotFound
+	GetDomainByName(name string) (*Domain, error)
+
+	// GetBindFailure 获取域名的绑定失败记录,不存在时返回 ID 为 0 的记录
+	GetBindFailure(domain string) (*BindFailure, error)
+	SaveBindFailure(failure *BindFailure) error
+	GetBindFailures() ([]BindFailure, error)
+	ClearBindFailure(domain string) error
+
+	// AcquireLock 获取或续期跨进程的锁,锁被其他 owner 持有且未过期时返回 false
+	AcquireLock(name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(name, owner string) error
+
+	SaveRun(run *SyncRun) error
+	GetRuns(limit int) ([]SyncRun, error)
+	// PruneRuns 删除开始时间早于 before 的同步记录,返回删除的数量
+	PruneRuns(before time.Time) (int64, error)
+	AddAuditLog(log *AuditLog) error
+	GetAuditLogs(query AuditQuery) ([]AuditLog, error)
+}
+
+// Migrator 支持版本化迁移的存储,内存存储没有表结构因此不需要实现
+type Migrator interface {
+	SchemaVersion() (int, error)
+	MigrateTo(version int) error
+}
+
+var _ Migrator = (*SSLDao)(nil)
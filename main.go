@@ -1,30 +1,79 @@
 package main
 
 import (
-	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/redact"
+	"github.com/muxi-Infra/autossl-qiniuyun/server"
 )
 
 func main() {
-	app, err := InitApp()
+	// 所有日志在输出前去除证书、私钥和配置中的密钥
+	log.SetOutput(redact.Writer(os.Stderr))
+
+	var flags config.Flags
+	flag.BoolVar(&flags.DryRun, "dry-run", false, "只输出计划,不申请、上传、绑定或保存证书")
+	flag.Usage = usage
+	flag.Parse()
+
+	// 收到 SIGINT/SIGTERM 后取消根 context,等待正在处理的任务结束后退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 不带命令或者 serve 时启动常驻服务,其余为一次性命令
+	args := flag.Args()
+	if len(args) > 0 && args[0] != "serve" {
+		if err := runCommand(ctx, flags, args); err != nil {
+			log.Println(err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
+	app, err := InitApp(flags)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	app.Serve()
-	return
+
+	app.Serve(ctx)
+	log.Println("服务已退出")
 }
 
 type App struct {
-	corn cron.Corn
+	corn   cron.Corn
+	server *server.Server
 }
 
-func NewApp(cron cron.Corn) (*App, error) {
+func NewApp(cron cron.Corn, server *server.Server) (*App, error) {
 	return &App{
-		corn: cron,
+		corn:   cron,
+		server: server,
 	}, nil
 }
 
-func (app *App) Serve() {
-	app.corn.Start()
+func (app *App) Serve(ctx context.Context) {
+	// 管理接口与定时任务并行运行,ctx 取消后两者都会退出
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := app.server.Run(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
+
+	app.corn.Start(ctx)
+	<-done
+	// 管理接口触发的同步或续期可能仍在后台执行
+	app.corn.Wait()
 }
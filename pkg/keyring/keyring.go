@@ -0,0 +1,203 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize 主密钥及数据密钥的长度,使用 AES-256-GCM
+const KeySize = 32
+
+// prefix 加密后数据的前缀,用于区分历史的明文数据
+const prefix = "enc:v1:"
+
+// ErrUnknownKey 数据使用的主密钥不在密钥环中
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Keyring 版本化的主密钥,第一个密钥用于加密,其余的只用于解密历史数据。
+// 每条数据使用随机的数据密钥加密,数据密钥再由主密钥加密后与密文一起保存(信封加密)
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// Parse 解析主密钥,每个密钥的格式为 <key-id>:<base64>,多个密钥以换行或逗号分隔,
+// 第一个密钥为当前用于加密的密钥
+func Parse(data string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	entries := strings.FieldsFunc(data, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("主密钥格式应为 <key-id>:<base64>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("主密钥 %s 不是合法的 base64:%w", id, err)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("主密钥 %s 的长度应为 %d 字节", id, KeySize)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("主密钥 %s 重复", id)
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = key
+	}
+	if k.current == "" {
+		return nil, errors.New("没有配置主密钥")
+	}
+	return k, nil
+}
+
+// Load 依次从环境变量和文件读取主密钥,都没有配置时返回 nil 表示不加密
+func Load(env, file string) (*Keyring, error) {
+	if env != "" {
+		if data := os.Getenv(env); data != "" {
+			return Parse(data)
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件失败:%w", err)
+		}
+		return Parse(string(data))
+	}
+	return nil, nil
+}
+
+// Generate 生成一个新的主密钥,格式与 Parse 相同
+func Generate(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, ":,\n") {
+		return "", fmt.Errorf("key-id 不能为空,且不能包含 ':' ',' 或换行")
+	}
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// CurrentID 返回当前用于加密的主密钥 ID
+func (k *Keyring) CurrentID() string {
+	return k.current
+}
+
+// Encrypt 使用当前主密钥加密,结果格式为 enc:v1:<key-id>:<加密的数据密钥>:<密文>
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.current], dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.current + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt 解密 Encrypt 的结果,未加密的数据原样返回
+func (k *Keyring) Decrypt(data string) (string, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(data, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("加密数据格式错误")
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("加密数据格式错误:%w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("加密数据格式错误:%w", err)
+	}
+	dek, err := open(key, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("解密数据密钥失败:%w", err)
+	}
+	plaintext, err := open(dek, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("解密数据失败:%w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation 数据未加密或不是使用当前主密钥加密时返回 true
+func (k *Keyring) NeedsRotation(data string) bool {
+	return KeyID(data) != k.current
+}
+
+// IsEncrypted 判断数据是否已经加密
+func IsEncrypted(data string) bool {
+	return strings.HasPrefix(data, prefix)
+}
+
+// KeyID 返回加密数据使用的主密钥 ID,未加密时返回空字符串
+func KeyID(data string) string {
+	if !IsEncrypted(data) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(data, prefix), ":")
+	return id
+}
+
+// seal 使用 AES-GCM 加密,随机 nonce 放在密文之前
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度错误")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
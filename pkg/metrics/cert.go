@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cert 证书指标需要的信息
+type Cert struct {
+	CertID   string
+	Domain   string
+	NotAfter time.Time
+}
+
+var (
+	certExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "certificate", "expiry_timestamp_seconds"),
+		"证书过期时间",
+		[]string{"cert_id", "domain"}, nil,
+	)
+	certDaysRemainingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "certificate", "days_remaining"),
+		"证书剩余有效天数",
+		[]string{"cert_id", "domain"}, nil,
+	)
+)
+
+// certCollector 每次抓取时从数据源读取证书,保证指标与数据库一致
+type certCollector struct {
+	list func() ([]Cert, error)
+}
+
+// RegisterCerts 注册证书过期相关的指标,list 在每次抓取时调用
+func RegisterCerts(list func() ([]Cert, error)) error {
+	return Registry.Register(&certCollector{list: list})
+}
+
+func (c *certCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certExpiryDesc
+	ch <- certDaysRemainingDesc
+}
+
+func (c *certCollector) Collect(ch chan<- prometheus.Metric) {
+	certs, err := c.list()
+	if err != nil {
+		log.Printf("获取证书指标失败:%v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, cert := range certs {
+		ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue,
+			float64(cert.NotAfter.Unix()), cert.CertID, cert.Domain)
+		ch <- prometheus.MustNewConstMetric(certDaysRemainingDesc, prometheus.GaugeValue,
+			cert.NotAfter.Sub(now).Hours()/24, cert.CertID, cert.Domain)
+	}
+}
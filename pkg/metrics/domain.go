@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Domain 域名绑定状态指标需要的信息
+type Domain struct {
+	Domain string
+	Group  string
+	Bound  bool
+}
+
+var domainBoundDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "domain", "https_bound"),
+	"域名最近一次绑定证书是否成功,1 为成功,0 为失败",
+	[]string{"domain", "group"}, nil,
+)
+
+// domainCollector 每次抓取时从数据源读取域名的绑定状态,重启后不会丢失
+type domainCollector struct {
+	list func() ([]Domain, error)
+}
+
+// RegisterDomains 注册域名绑定状态的指标,list 在每次抓取时调用
+func RegisterDomains(list func() ([]Domain, error)) error {
+	return Registry.Register(&domainCollector{list: list})
+}
+
+func (c *domainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- domainBoundDesc
+}
+
+func (c *domainCollector) Collect(ch chan<- prometheus.Metric) {
+	domains, err := c.list()
+	if err != nil {
+		log.Printf("获取域名指标失败:%v", err)
+		return
+	}
+
+	for _, domain := range domains {
+		value := 0.0
+		if domain.Bound {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(domainBoundDesc, prometheus.GaugeValue, value, domain.Domain, domain.Group)
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "autossl"
+
+// Registry 所有指标注册在这里,避免与默认 Registry 中的指标冲突
+var Registry = prometheus.NewRegistry()
+
+var (
+	acmeObtainTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "acme_obtain_total",
+		Help:      "ACME 申请证书的次数,按结果区分",
+	}, []string{"domain", "result"})
+
+	qiniuRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "qiniu_request_duration_seconds",
+		Help:      "七牛云 API 单次请求的耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	qiniuRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qiniu_request_errors_total",
+		Help:      "七牛云 API 请求失败的次数,status 为 0 表示网络错误",
+	}, []string{"endpoint", "status"})
+
+	runDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "每次完整同步的耗时",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+
+	lastRunTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "最近一次同步结束的时间,按结果区分",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		acmeObtainTotal,
+		qiniuRequestDuration,
+		qiniuRequestErrors,
+		runDuration,
+		lastRunTimestamp,
+	)
+}
+
+// Handler 返回 /metrics 的处理器
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveACMEObtain 记录一次证书申请的结果
+func ObserveACMEObtain(domain string, err error) {
+	acmeObtainTotal.WithLabelValues(domain, result(err)).Inc()
+}
+
+// ObserveQiniuRequest 记录一次七牛云请求,status 为 HTTP 状态码,网络错误时为 0
+func ObserveQiniuRequest(endpoint string, duration time.Duration, status int, err error) {
+	qiniuRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	if err != nil {
+		qiniuRequestErrors.WithLabelValues(endpoint, strconv.Itoa(status)).Inc()
+	}
+}
+
+// ObserveRun 记录一次完整同步的耗时和结果
+func ObserveRun(duration time.Duration, err error) {
+	runDuration.Observe(duration.Seconds())
+	lastRunTimestamp.WithLabelValues(result(err)).SetToCurrentTime()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,185 @@
+package psl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DefaultURL Public Suffix List 的官方地址
+const DefaultURL = "https://publicsuffix.org/list/public_suffix_list.dat"
+
+// List 解析后的 Public Suffix List
+type List struct {
+	rules      map[string]struct{} // 普通规则,如 com.cn
+	wildcards  map[string]struct{} // 通配规则,存储去掉 "*." 之后的部分,如 *.ck 存储为 ck
+	exceptions map[string]struct{} // 例外规则,存储去掉 "!" 之后的部分,如 !www.ck 存储为 www.ck
+}
+
+// Parse 解析 public_suffix_list.dat 格式的内容
+func Parse(r io.Reader) (*List, error) {
+	l := &List{
+		rules:      make(map[string]struct{}),
+		wildcards:  make(map[string]struct{}),
+		exceptions: make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// 每行只取第一个空白字符之前的内容
+		rule := strings.ToLower(strings.Fields(line)[0])
+		// 下载到错误页面等内容时拒绝解析,避免替换掉可用的列表
+		if !validRule(rule) {
+			return nil, fmt.Errorf("invalid public suffix rule: %q", rule)
+		}
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			l.exceptions[strings.TrimPrefix(rule, "!")] = struct{}{}
+		case strings.HasPrefix(rule, "*."):
+			l.wildcards[strings.TrimPrefix(rule, "*.")] = struct{}{}
+		default:
+			l.rules[rule] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(l.rules) == 0 {
+		return nil, fmt.Errorf("public suffix list is empty")
+	}
+	return l, nil
+}
+
+// validRule 规则只能由字母(包括国际化域名)、数字、'-' 和 '.' 组成,可以带有 "*." 或 "!" 前缀
+func validRule(rule string) bool {
+	rule = strings.TrimPrefix(strings.TrimPrefix(rule, "!"), "*.")
+	if rule == "" || strings.HasPrefix(rule, ".") || strings.HasSuffix(rule, ".") {
+		return false
+	}
+	for _, r := range rule {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) && r != '-' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicSuffix 返回域名的公共后缀,没有匹配的规则时使用默认规则 "*"
+func (l *List) PublicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	// 从最长的后缀开始查找,第一个命中的就是标签最多的规则
+	for i := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if _, ok := l.exceptions[suffix]; ok {
+			return strings.Join(labels[i+1:], ".")
+		}
+		if _, ok := l.rules[suffix]; ok {
+			return suffix
+		}
+		if i+1 < len(labels) {
+			if _, ok := l.wildcards[strings.Join(labels[i+1:], ".")]; ok {
+				return suffix
+			}
+		}
+	}
+	return labels[len(labels)-1]
+}
+
+// Resolver 默认使用 golang.org/x/net/publicsuffix 内置的快照,Refresh 成功后使用下载的列表
+type Resolver struct {
+	mu        sync.RWMutex
+	list      *List
+	updatedAt time.Time // 最近一次成功下载的时间
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+// Refresh 从 url 下载最新的 Public Suffix List,失败时继续使用之前的列表
+func (r *Resolver) Refresh(ctx context.Context, client *http.Client, url string) error {
+	if url == "" {
+		url = DefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download public suffix list failed: %s", resp.Status)
+	}
+
+	list, err := Parse(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.list = list
+	r.updatedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// Stale 判断距离上次成功下载是否已经超过 interval,从未下载过时返回 true
+func (r *Resolver) Stale(interval time.Duration) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return time.Since(r.updatedAt) > interval
+}
+
+// PublicSuffix 返回域名的公共后缀,如 example.com.cn 返回 com.cn
+func (r *Resolver) PublicSuffix(domain string) string {
+	domain = normalize(domain)
+
+	r.mu.RLock()
+	list := r.list
+	r.mu.RUnlock()
+
+	if list != nil {
+		return list.PublicSuffix(domain)
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix
+}
+
+// IsPublicSuffix 判断域名本身是否就是公共后缀,公共后缀无法申请证书
+func (r *Resolver) IsPublicSuffix(domain string) bool {
+	return r.PublicSuffix(domain) == normalize(domain)
+}
+
+// RegistrableDomain 返回可注册域名(公共后缀再加一级),如 a.b.example.co.uk 返回 example.co.uk
+func (r *Resolver) RegistrableDomain(domain string) (string, error) {
+	domain = normalize(domain)
+	suffix := r.PublicSuffix(domain)
+	if domain == suffix {
+		return "", fmt.Errorf("%s is a public suffix", domain)
+	}
+
+	rest := strings.TrimSuffix(domain, "."+suffix)
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest = rest[i+1:]
+	}
+	return rest + "." + suffix, nil
+}
+
+func normalize(domain string) string {
+	return strings.ToLower(strings.Trim(domain, "."))
+}
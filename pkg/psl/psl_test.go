@@ -0,0 +1,143 @@
+package psl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testList = `// ===BEGIN ICANN DOMAINS===
+com
+cn
+com.cn
+uk
+co.uk
+
+// ck : https://www.iana.org/domains/root/db/ck.html
+*.ck
+!www.ck
+dev.example   trailing text is ignored
+`
+
+func TestParse(t *testing.T) {
+	l, err := Parse(strings.NewReader(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.rules) != 6 || len(l.wildcards) != 1 || len(l.exceptions) != 1 {
+		t.Fatalf("rules=%v wildcards=%v exceptions=%v", l.rules, l.wildcards, l.exceptions)
+	}
+	if _, ok := l.rules["dev.example"]; !ok {
+		t.Fatalf("rules = %v, want dev.example", l.rules)
+	}
+
+	// 国际化域名的规则
+	if _, err := Parse(strings.NewReader("公司.hk\nசிங்கப்பூர்\n")); err != nil {
+		t.Fatalf("Parse() of IDN rules: %v", err)
+	}
+	for _, data := range []string{"// only comments\n\n", "<html>not a list</html>", "com\n.com\n", "com\n*.\n"} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded", data)
+		}
+	}
+}
+
+func TestListPublicSuffix(t *testing.T) {
+	l, err := Parse(strings.NewReader(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "com"},
+		{"a.b.example.com.cn", "com.cn"},
+		{"example.co.uk", "co.uk"},
+		{"co.uk", "co.uk"},
+		// 通配规则匹配任意一级
+		{"foo.ck", "foo.ck"},
+		{"a.foo.ck", "foo.ck"},
+		// 例外规则优先于通配规则
+		{"www.ck", "ck"},
+		{"a.www.ck", "ck"},
+		// 没有匹配的规则时使用默认规则 "*"
+		{"example.unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := l.PublicSuffix(tt.domain); got != tt.want {
+			t.Errorf("PublicSuffix(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	r := NewResolver()
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{"a.b.example.com.cn", "example.com.cn", false},
+		{"example.co.uk", "example.co.uk", false},
+		{"img.static.example.co.uk", "example.co.uk", false},
+		{"WWW.Example.COM.", "example.com", false},
+		{"co.uk", "", true},
+		{"com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := r.RegistrableDomain(tt.domain)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("RegistrableDomain(%q) = %q, %v, want %q, error %v", tt.domain, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if !r.IsPublicSuffix("co.uk") || r.IsPublicSuffix("example.co.uk") {
+		t.Error("IsPublicSuffix mismatch for co.uk/example.co.uk")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	body := testList
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	r := NewResolver()
+	if !r.Stale(time.Hour) {
+		t.Fatal("new resolver is not stale")
+	}
+	// 内置快照中没有 dev.example
+	if got, _ := r.RegistrableDomain("a.b.dev.example"); got != "dev.example" {
+		t.Fatalf("builtin RegistrableDomain = %q, want dev.example", got)
+	}
+
+	if err := r.Refresh(ctx, srv.Client(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if r.Stale(time.Hour) {
+		t.Fatal("resolver is stale right after refresh")
+	}
+	if got, _ := r.RegistrableDomain("a.b.dev.example"); got != "b.dev.example" {
+		t.Fatalf("RegistrableDomain after refresh = %q, want b.dev.example", got)
+	}
+
+	// 下载失败或内容无法解析时继续使用之前的列表
+	body = "<html>not a list</html>"
+	if err := r.Refresh(ctx, srv.Client(), srv.URL); err == nil {
+		t.Fatal("Refresh() with bad body succeeded")
+	}
+	body, status = "", http.StatusInternalServerError
+	if err := r.Refresh(ctx, srv.Client(), srv.URL); err == nil {
+		t.Fatal("Refresh() with status 500 succeeded")
+	}
+	if got, _ := r.RegistrableDomain("a.b.dev.example"); got != "b.dev.example" {
+		t.Fatalf("RegistrableDomain after failed refresh = %q, want b.dev.example", got)
+	}
+}
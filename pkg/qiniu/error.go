@@ -0,0 +1,59 @@
+package qiniu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 可以使用 errors.Is 判断的错误类型
+var (
+	ErrNotFound    = errors.New("qiniu: resource not found")
+	ErrRateLimited = errors.New("qiniu: rate limited")
+	ErrAuth        = errors.New("qiniu: authentication failed")
+)
+
+// 七牛云使用 573 表示单用户请求过于频繁
+const statusTooManyRequests = 573
+
+// APIError 七牛云返回的非 2xx 响应
+type APIError struct {
+	StatusCode int    `json:"-"`     // HTTP 状态码
+	Code       int    `json:"code"`  // 七牛云错误码
+	Message    string `json:"error"` // 七牛云错误信息
+	RequestID  string `json:"-"`     // X-Reqid 响应头,排查问题时提供给七牛云
+
+	RetryAfter time.Duration `json:"-"` // Retry-After 响应头,没有时为 0
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("qiniu api error: status=%d code=%d error=%q reqid=%s", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// Is 让 errors.Is 可以将 APIError 与哨兵错误进行匹配
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.Code == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == statusTooManyRequests
+	case ErrAuth:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	default:
+		return false
+	}
+}
+
+// newAPIError 根据响应构造 APIError,响应体不是 json 时将原始内容作为错误信息
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = string(body)
+	}
+	apiErr.StatusCode = resp.StatusCode
+	apiErr.RequestID = resp.Header.Get("X-Reqid")
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
@@ -1,28 +1,132 @@
 package qiniu
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/qiniu/go-sdk/v7/auth"
+	"iter"
 	"net/http"
+	"time"
+
+	"github.com/qiniu/go-sdk/v7/auth"
+	"golang.org/x/time/rate"
 )
 
-func NewQiniuClient(accessKey string, secretKey string) *QiniuClient {
-	return &QiniuClient{
+func NewQiniuClient(accessKey string, secretKey string, opts ...Option) *QiniuClient {
+	c := &QiniuClient{
 		qiniuClient: auth.New(accessKey, secretKey),
 		client:      http.DefaultClient,
+		baseUrl:     QiniuBaseUrl,
+		limiter:     newLimiter(DefaultRateLimit, DefaultBurst),
+		retryPolicy: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 const QiniuBaseUrl = "https://api.qiniu.com"
 
+const (
+	domainPageLimit = 1000 // 域名列表单页最大数量
+	certPageLimit   = 500  // 证书列表单页最大数量
+)
+
 type QiniuClient struct {
 	qiniuClient *auth.Credentials
 	client      *http.Client
+	baseUrl     string
+	limiter     *limiter
+	retryPolicy RetryPolicy
+	observer    Observer
+}
+
+// Observer 每次请求结束后调用,用于统计耗时和错误,status 为 0 表示没有收到响应
+type Observer func(endpoint string, duration time.Duration, status int, err error)
+
+// Option 用于自定义 QiniuClient
+type Option func(c *QiniuClient)
+
+// WithBaseURL 替换七牛云 API 地址,主要用于测试
+func WithBaseURL(baseUrl string) Option {
+	return func(c *QiniuClient) {
+		c.baseUrl = baseUrl
+	}
+}
+
+// WithHTTPClient 替换默认的 http.Client
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *QiniuClient) {
+		c.client = client
+	}
+}
+
+// WithRateLimit 设置每个接口每秒的请求数和突发数量,limit 小于等于 0 时不修改
+func WithRateLimit(limit float64, burst int) Option {
+	return func(c *QiniuClient) {
+		if limit <= 0 {
+			return
+		}
+		if burst <= 0 {
+			burst = 1
+		}
+		c.limiter = newLimiter(rate.Limit(limit), burst)
+	}
+}
+
+// WithRetryPolicy 替换默认的重试策略
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *QiniuClient) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithObserver 设置请求观察者
+func WithObserver(observer Observer) Option {
+	return func(c *QiniuClient) {
+		c.observer = observer
+	}
+}
+
+// GetDomainList 获取全部域名,会自动翻页
+func (c *QiniuClient) GetDomainList(ctx context.Context) (GetDomainResp, error) {
+	var resp GetDomainResp
+	for domain, err := range c.Domains(ctx) {
+		if err != nil {
+			return GetDomainResp{}, err
+		}
+		resp.Domains = append(resp.Domains, domain)
+	}
+	return resp, nil
+}
+
+// Domains 返回遍历全部域名的迭代器,按照 marker 逐页请求,出错时迭代结束
+func (c *QiniuClient) Domains(ctx context.Context) iter.Seq2[Domain, error] {
+	return func(yield func(Domain, error) bool) {
+		marker := ""
+		for {
+			page, err := c.GetDomainPage(ctx, marker)
+			if err != nil {
+				yield(Domain{}, err)
+				return
+			}
+			for _, domain := range page.Domains {
+				if !yield(domain, nil) {
+					return
+				}
+			}
+			if page.Marker == "" || len(page.Domains) == 0 {
+				return
+			}
+			marker = page.Marker
+		}
+	}
 }
 
-func (c *QiniuClient) GetDomainList() (GetDomainResp, error) {
+// GetDomainPage 获取单页域名列表,marker 为空表示第一页
+func (c *QiniuClient) GetDomainPage(ctx context.Context, marker string) (GetDomainResp, error) {
 	var resp GetDomainResp
-	data, err := c.newReq(http.MethodGet, "/domain", GetDomainReq{Limit: 1000})
+	data, err := c.newReq(ctx, http.MethodGet, "/domain", GetDomainReq{Marker: marker, Limit: domainPageLimit})
 	if err != nil {
 		return GetDomainResp{}, err
 	}
@@ -35,9 +139,9 @@ func (c *QiniuClient) GetDomainList() (GetDomainResp, error) {
 }
 
 // 上传ssl证书
-func (c *QiniuClient) UPSSLCert(pri, ca, name string) (UPSSLCertResp, error) {
+func (c *QiniuClient) UPSSLCert(ctx context.Context, pri, ca, name string) (UPSSLCertResp, error) {
 	var resp UPSSLCertResp
-	data, err := c.newReq(http.MethodPost, "/sslcert", UPSSLCertReq{Name: name, CommonName: name, Pri: pri, Ca: ca})
+	data, err := c.newReq(ctx, http.MethodPost, "/sslcert", UPSSLCertReq{Name: name, CommonName: name, Pri: pri, Ca: ca})
 	if err != nil {
 		return UPSSLCertResp{}, err
 	}
@@ -49,10 +153,45 @@ func (c *QiniuClient) UPSSLCert(pri, ca, name string) (UPSSLCertResp, error) {
 	return resp, nil
 }
 
-// 获取ssl证书列表
-func (c *QiniuClient) GETSSLCertList() (GetSSLCertListResp, error) {
+// 获取全部ssl证书列表,会自动翻页
+func (c *QiniuClient) GETSSLCertList(ctx context.Context) (GetSSLCertListResp, error) {
 	var resp GetSSLCertListResp
-	data, err := c.newReq(http.MethodGet, "/sslcert", GetSSLCertListReq{Limit: 500})
+	for cert, err := range c.SSLCerts(ctx) {
+		if err != nil {
+			return GetSSLCertListResp{}, err
+		}
+		resp.Certs = append(resp.Certs, cert)
+	}
+	return resp, nil
+}
+
+// SSLCerts 返回遍历全部ssl证书的迭代器,按照 marker 逐页请求,出错时迭代结束
+func (c *QiniuClient) SSLCerts(ctx context.Context) iter.Seq2[Cert, error] {
+	return func(yield func(Cert, error) bool) {
+		marker := ""
+		for {
+			page, err := c.GetSSLCertPage(ctx, marker)
+			if err != nil {
+				yield(Cert{}, err)
+				return
+			}
+			for _, cert := range page.Certs {
+				if !yield(cert, nil) {
+					return
+				}
+			}
+			if page.Marker == "" || len(page.Certs) == 0 {
+				return
+			}
+			marker = page.Marker
+		}
+	}
+}
+
+// GetSSLCertPage 获取单页ssl证书列表,marker 为空表示第一页
+func (c *QiniuClient) GetSSLCertPage(ctx context.Context, marker string) (GetSSLCertListResp, error) {
+	var resp GetSSLCertListResp
+	data, err := c.newReq(ctx, http.MethodGet, "/sslcert", GetSSLCertListReq{Marker: marker, Limit: certPageLimit})
 	if err != nil {
 		return GetSSLCertListResp{}, err
 	}
@@ -64,37 +203,96 @@ func (c *QiniuClient) GETSSLCertList() (GetSSLCertListResp, error) {
 	return resp, nil
 }
 
-// 使用certId获取ssl证书
-func (c *QiniuClient) GETSSLCertById(certId string) (GetSSLCertByIDResp, error) {
+// 使用certId获取ssl证书,证书不存在时返回的错误满足 errors.Is(err, ErrNotFound)
+func (c *QiniuClient) GETSSLCertById(ctx context.Context, certId string) (GetSSLCertByIDResp, error) {
 	var resp GetSSLCertByIDResp
-	//如果存在则不会报错,这里没有去查错误码 TODO 使用错误码进行精确对应
-	data, err := c.newReq(http.MethodGet, "/sslcert/"+certId, nil)
+	data, err := c.newReq(ctx, http.MethodGet, "/sslcert/"+certId, nil)
 	if err != nil {
 		return GetSSLCertByIDResp{}, err
 	}
 	err = json.Unmarshal(data, &resp)
 	if err != nil {
 		return GetSSLCertByIDResp{}, err
+	}
 
+	// 部分情况下七牛云返回 200 但是在响应体中携带错误码
+	if resp.Code != 0 && resp.Code != http.StatusOK {
+		return GetSSLCertByIDResp{}, &APIError{StatusCode: http.StatusOK, Code: resp.Code, Message: resp.Error}
 	}
 	return resp, nil
 }
 
-// 删除证书
-func (c *QiniuClient) RemoveSSLCert(certId string) error {
-	_, err := c.newReq(http.MethodPost, "/sslcert/"+certId, nil)
+// GetSSLCert 获取证书详情并解析有效期,证书不存在时返回的错误满足 errors.Is(err, ErrNotFound)
+func (c *QiniuClient) GetSSLCert(ctx context.Context, certId string) (SSLCert, error) {
+	resp, err := c.GETSSLCertById(ctx, certId)
+	if err != nil {
+		return SSLCert{}, err
+	}
+	return SSLCert{
+		CertID:     resp.Cert.Certid,
+		Name:       resp.Cert.Name,
+		CommonName: resp.Cert.CommonName,
+		DNSNames:   resp.Cert.Dnsnames,
+		NotBefore:  time.Unix(int64(resp.Cert.NotBefore), 0),
+		NotAfter:   time.Unix(int64(resp.Cert.NotAfter), 0),
+		CreateTime: time.Unix(int64(resp.Cert.CreateTime), 0),
+		Enable:     resp.Cert.Enable,
+	}, nil
+}
+
+// ListSSLCerts 获取满足过滤条件的全部证书,过滤条件为空时返回全部证书
+func (c *QiniuClient) ListSSLCerts(ctx context.Context, filter SSLCertFilter) ([]Cert, error) {
+	var certs []Cert
+	for cert, err := range c.SSLCerts(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if filter.match(cert) {
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+// 删除证书,证书不存在时返回的错误满足 errors.Is(err, ErrNotFound)
+func (c *QiniuClient) RemoveSSLCert(ctx context.Context, certId string) error {
+	_, err := c.newReq(ctx, http.MethodDelete, "/sslcert/"+certId, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// RenameSSLCert 修改证书名称
+func (c *QiniuClient) RenameSSLCert(ctx context.Context, certId, name string) error {
+	_, err := c.newReq(ctx, http.MethodPut, "/sslcert/"+certId+"/name", RenameSSLCertReq{Name: name})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDomainDetail 获取域名详情,包括当前的 https 配置
+func (c *QiniuClient) GetDomainDetail(ctx context.Context, name string) (DomainDetail, error) {
+	var resp DomainDetail
+	data, err := c.newReq(ctx, http.MethodGet, "/domain/"+name, nil)
+	if err != nil {
+		return DomainDetail{}, err
+	}
+
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return DomainDetail{}, err
+	}
+	return resp, nil
+}
+
 // 修改绑定的证书并开启https
-func (c *QiniuClient) ForceHTTPS(name, certID string) error {
-	_, err := c.newReq(http.MethodPut, "/domain/"+name+"/sslize", ForceHTTPSReq{
+func (c *QiniuClient) ForceHTTPS(ctx context.Context, name, certID string, opts HTTPSOptions) error {
+	_, err := c.newReq(ctx, http.MethodPut, "/domain/"+name+"/sslize", ForceHTTPSReq{
 		CertId:      certID,
-		ForceHttps:  false, //默认关闭强制https
-		Http2Enable: false, //默认关闭http2强制
+		ForceHttps:  opts.ForceHttps,
+		Http2Enable: opts.Http2Enable,
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,342 @@
+package qiniu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// page 测试服务器对某个 marker 返回的响应
+type page struct {
+	status int
+	body   any
+}
+
+// newPagedServer 按请求中的 marker 返回对应的页面,并记录请求过的 marker
+func newPagedServer(t *testing.T, path string, pages map[string]page) (*QiniuClient, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var markers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != path {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		marker := r.URL.Query().Get("marker")
+		mu.Lock()
+		markers = append(markers, marker)
+		mu.Unlock()
+
+		p, ok := pages[marker]
+		if !ok {
+			t.Errorf("unexpected marker %q", marker)
+			http.NotFound(w, r)
+			return
+		}
+		if p.status != 0 {
+			w.WriteHeader(p.status)
+		}
+		_ = json.NewEncoder(w).Encode(p.body)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewQiniuClient("ak", "sk", WithBaseURL(srv.URL), WithRetryPolicy(RetryPolicy{}))
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(markers)
+	}
+}
+
+func TestDomainsWalksMarkers(t *testing.T) {
+	client, markers := newPagedServer(t, "/domain", map[string]page{
+		"":   {body: GetDomainResp{Marker: "m1", Domains: []Domain{{Name: "a.example.com"}, {Name: "b.example.com"}}}},
+		"m1": {body: GetDomainResp{Marker: "m2", Domains: []Domain{{Name: "c.example.com"}}}},
+		// 最后一页为空但仍然带有 marker,迭代也应结束
+		"m2": {body: GetDomainResp{Marker: "m3"}},
+	})
+
+	var names []string
+	for domain, err := range client.Domains(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, domain.Name)
+	}
+	if want := []string{"a.example.com", "b.example.com", "c.example.com"}; !slices.Equal(names, want) {
+		t.Fatalf("domains = %v, want %v", names, want)
+	}
+	if want := []string{"", "m1", "m2"}; !slices.Equal(markers(), want) {
+		t.Fatalf("markers = %v, want %v", markers(), want)
+	}
+}
+
+func TestDomainsErrorMidway(t *testing.T) {
+	client, markers := newPagedServer(t, "/domain", map[string]page{
+		"":   {body: GetDomainResp{Marker: "m1", Domains: []Domain{{Name: "a.example.com"}}}},
+		"m1": {status: http.StatusInternalServerError, body: map[string]any{"code": 500, "error": "internal"}},
+	})
+
+	var names []string
+	var gotErr error
+	for domain, err := range client.Domains(context.Background()) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		names = append(names, domain.Name)
+	}
+	var apiErr *APIError
+	if !errors.As(gotErr, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want APIError 500", gotErr)
+	}
+	if !slices.Equal(names, []string{"a.example.com"}) {
+		t.Fatalf("domains before error = %v", names)
+	}
+	if want := []string{"", "m1"}; !slices.Equal(markers(), want) {
+		t.Fatalf("markers = %v, want %v", markers(), want)
+	}
+
+	// GetDomainList 出错时不返回部分结果
+	resp, err := client.GetDomainList(context.Background())
+	if err == nil || len(resp.Domains) != 0 {
+		t.Fatalf("GetDomainList = %v, %v, want error and no domains", resp.Domains, err)
+	}
+}
+
+func TestSSLCertsWalksMarkers(t *testing.T) {
+	client, markers := newPagedServer(t, "/sslcert", map[string]page{
+		"":   {body: GetSSLCertListResp{Marker: "m1", Certs: []Cert{{CertId: "c1"}}}},
+		"m1": {body: GetSSLCertListResp{Marker: "m2", Certs: []Cert{{CertId: "c2"}, {CertId: "c3"}}}},
+		"m2": {body: GetSSLCertListResp{}},
+	})
+
+	var ids []string
+	for cert, err := range client.SSLCerts(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cert.CertId)
+	}
+	if want := []string{"c1", "c2", "c3"}; !slices.Equal(ids, want) {
+		t.Fatalf("certs = %v, want %v", ids, want)
+	}
+	if want := []string{"", "m1", "m2"}; !slices.Equal(markers(), want) {
+		t.Fatalf("markers = %v, want %v", markers(), want)
+	}
+}
+
+func TestSSLCertsErrorMidway(t *testing.T) {
+	client, _ := newPagedServer(t, "/sslcert", map[string]page{
+		"":   {body: GetSSLCertListResp{Marker: "m1", Certs: []Cert{{CertId: "c1"}}}},
+		"m1": {status: http.StatusUnauthorized, body: map[string]any{"code": 401, "error": "bad token"}},
+	})
+
+	var ids []string
+	var gotErr error
+	for cert, err := range client.SSLCerts(context.Background()) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		ids = append(ids, cert.CertId)
+	}
+	if !errors.Is(gotErr, ErrAuth) {
+		t.Fatalf("err = %v, want ErrAuth", gotErr)
+	}
+	if !slices.Equal(ids, []string{"c1"}) {
+		t.Fatalf("certs before error = %v", ids)
+	}
+}
+
+func TestSSLCertsStopEarly(t *testing.T) {
+	client, markers := newPagedServer(t, "/sslcert", map[string]page{
+		"": {body: GetSSLCertListResp{Marker: "m1", Certs: []Cert{{CertId: "c1"}, {CertId: "c2"}}}},
+	})
+
+	// 调用方提前结束迭代时不再请求下一页
+	for cert, err := range client.SSLCerts(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.CertId == "c1" {
+			break
+		}
+	}
+	if want := []string{""}; !slices.Equal(markers(), want) {
+		t.Fatalf("markers = %v, want %v", markers(), want)
+	}
+}
+
+func TestListSSLCertsFilter(t *testing.T) {
+	pages := map[string]page{
+		"": {body: GetSSLCertListResp{Marker: "m1", Certs: []Cert{
+			{CertId: "c1", Name: "example.com", CommonName: "example.com"},
+			{CertId: "c2", Name: "manual", CommonName: "example.com"},
+		}}},
+		"m1": {body: GetSSLCertListResp{Marker: "m2", Certs: []Cert{
+			{CertId: "c3", Name: "example.org", CommonName: "example.org"},
+		}}},
+		"m2": {body: GetSSLCertListResp{Certs: []Cert{
+			{CertId: "c4", Name: "example.com", CommonName: "www.example.com"},
+		}}},
+	}
+
+	tests := []struct {
+		name   string
+		filter SSLCertFilter
+		want   []string
+	}{
+		{"all", SSLCertFilter{}, []string{"c1", "c2", "c3", "c4"}},
+		{"name", SSLCertFilter{Name: "example.com"}, []string{"c1", "c4"}},
+		{"common name", SSLCertFilter{CommonName: "example.com"}, []string{"c1", "c2"}},
+		{"both", SSLCertFilter{Name: "example.com", CommonName: "www.example.com"}, []string{"c4"}},
+		{"no match", SSLCertFilter{Name: "example.net"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, markers := newPagedServer(t, "/sslcert", pages)
+			certs, err := client.ListSSLCerts(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, cert := range certs {
+				ids = append(ids, cert.CertId)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Fatalf("certs = %v, want %v", ids, tt.want)
+			}
+			// 过滤在本地进行,始终读取全部页面
+			if want := []string{"", "m1", "m2"}; !slices.Equal(markers(), want) {
+				t.Fatalf("markers = %v, want %v", markers(), want)
+			}
+		})
+	}
+}
+
+func TestListSSLCertsError(t *testing.T) {
+	client, _ := newPagedServer(t, "/sslcert", map[string]page{
+		"":   {body: GetSSLCertListResp{Marker: "m1", Certs: []Cert{{CertId: "c1"}}}},
+		"m1": {status: http.StatusInternalServerError, body: map[string]any{"code": 500, "error": "internal"}},
+	})
+	certs, err := client.ListSSLCerts(context.Background(), SSLCertFilter{})
+	if err == nil || certs != nil {
+		t.Fatalf("ListSSLCerts = %v, %v, want error and no certs", certs, err)
+	}
+}
+
+func TestGetSSLCert(t *testing.T) {
+	notBefore := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	var resp GetSSLCertByIDResp
+	resp.Cert.Certid = "cert-1"
+	resp.Cert.Name = "example.com"
+	resp.Cert.CommonName = "example.com"
+	resp.Cert.Dnsnames = []string{"example.com", "*.example.com"}
+	resp.Cert.NotBefore = int(notBefore.Unix())
+	resp.Cert.NotAfter = int(notAfter.Unix())
+	resp.Cert.CreateTime = int(notBefore.Unix())
+	resp.Cert.Enable = true
+
+	client, requests := newRecordingServer(t, http.StatusOK, resp)
+	cert, err := client.GetSSLCert(context.Background(), "cert-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requests(); len(got) != 1 || got[0].method != http.MethodGet || got[0].path != "/sslcert/cert-1" {
+		t.Fatalf("requests = %+v, want GET /sslcert/cert-1", got)
+	}
+	if cert.CertID != "cert-1" || cert.Name != "example.com" || cert.CommonName != "example.com" || !cert.Enable {
+		t.Fatalf("cert = %+v", cert)
+	}
+	if !slices.Equal(cert.DNSNames, []string{"example.com", "*.example.com"}) {
+		t.Fatalf("DNSNames = %v", cert.DNSNames)
+	}
+	if !cert.NotBefore.Equal(notBefore) || !cert.NotAfter.Equal(notAfter) || !cert.CreateTime.Equal(notBefore) {
+		t.Fatalf("NotBefore/NotAfter/CreateTime = %s/%s/%s, want %s/%s", cert.NotBefore, cert.NotAfter, cert.CreateTime, notBefore, notAfter)
+	}
+}
+
+func TestGetSSLCertNotFound(t *testing.T) {
+	client, _ := newRecordingServer(t, http.StatusNotFound, map[string]any{"code": 404, "error": "cert not found"})
+	_, err := client.GetSSLCert(context.Background(), "cert-1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+// request 测试服务器收到的请求
+type request struct {
+	method string
+	path   string
+	body   string
+}
+
+// newRecordingServer 记录收到的请求并返回固定的响应
+func newRecordingServer(t *testing.T, status int, body any) (*QiniuClient, func() []request) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, request{method: r.Method, path: r.URL.Path, body: string(data)})
+		mu.Unlock()
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewQiniuClient("ak", "sk", WithBaseURL(srv.URL), WithRetryPolicy(RetryPolicy{}))
+	return client, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+}
+
+func TestRemoveSSLCert(t *testing.T) {
+	client, requests := newRecordingServer(t, http.StatusOK, map[string]any{})
+	if err := client.RemoveSSLCert(context.Background(), "cert-1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{method: http.MethodDelete, path: "/sslcert/cert-1"}}
+	if got := requests(); !slices.Equal(got, want) {
+		t.Fatalf("requests = %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoveSSLCertNotFound(t *testing.T) {
+	client, _ := newRecordingServer(t, http.StatusNotFound, map[string]any{"code": 404, "error": "cert not found"})
+	err := client.RemoveSSLCert(context.Background(), "cert-1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRenameSSLCert(t *testing.T) {
+	client, requests := newRecordingServer(t, http.StatusOK, map[string]any{})
+	if err := client.RenameSSLCert(context.Background(), "cert-1", "example.com-2026"); err != nil {
+		t.Fatal(err)
+	}
+	got := requests()
+	if len(got) != 1 || got[0].method != http.MethodPut || got[0].path != "/sslcert/cert-1/name" {
+		t.Fatalf("requests = %+v, want PUT /sslcert/cert-1/name", got)
+	}
+	var body RenameSSLCertReq
+	if err := json.Unmarshal([]byte(got[0].body), &body); err != nil {
+		t.Fatalf("body %q: %v", got[0].body, err)
+	}
+	if body.Name != "example.com-2026" {
+		t.Fatalf("body = %+v", body)
+	}
+}
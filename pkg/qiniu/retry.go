@@ -0,0 +1,128 @@
+package qiniu
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RetryPolicy 请求失败时的重试策略
+type RetryPolicy struct {
+	MaxRetries int           // 最大重试次数,0 表示不重试
+	BaseDelay  time.Duration // 第一次重试的退避时间,之后每次翻倍
+	MaxDelay   time.Duration // 单次退避时间的上限
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+// 默认每个接口的限流配置
+const (
+	DefaultRateLimit = 5 // 每秒请求数
+	DefaultBurst     = 5
+)
+
+// 修改域名配置会触发 CDN 重新部署,默认单独限制得更严格
+var defaultEndpointLimits = map[string]rate.Limit{
+	"PUT /domain/{name}/sslize": rate.Every(time.Second),
+}
+
+// backoff 计算第 attempt 次重试前的等待时间,使用 full jitter 避免多个请求同时重试
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << attempt
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// shouldRetry 判断请求是否可以重试,非幂等的请求只在明确被限流时重试,避免重复创建资源
+func shouldRetry(method string, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	idempotent := method != http.MethodPost
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return idempotent && apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	// 网络错误
+	return idempotent
+}
+
+// parseRetryAfter 解析 Retry-After 响应头,支持秒数和 HTTP 时间两种格式
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// endpointKey 将请求路径中的域名、证书ID替换为占位符,使同一接口共用一个令牌桶
+func endpointKey(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) > 1 {
+		parts[1] = "{name}"
+	}
+	return method + " /" + strings.Join(parts, "/")
+}
+
+// limiter 为每个接口维护独立的令牌桶
+type limiter struct {
+	limit     rate.Limit
+	burst     int
+	overrides map[string]rate.Limit
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+func newLimiter(limit rate.Limit, burst int) *limiter {
+	return &limiter{
+		limit:     limit,
+		burst:     burst,
+		overrides: defaultEndpointLimits,
+		buckets:   make(map[string]*rate.Limiter),
+	}
+}
+
+// wait 阻塞直到该接口拿到令牌或 ctx 被取消
+func (l *limiter) wait(ctx context.Context, endpoint string) error {
+	l.mu.Lock()
+	bucket, ok := l.buckets[endpoint]
+	if !ok {
+		limit, burst := l.limit, l.burst
+		if override, ok := l.overrides[endpoint]; ok && override < limit {
+			limit, burst = override, 1
+		}
+		bucket = rate.NewLimiter(limit, burst)
+		l.buckets[endpoint] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.Wait(ctx)
+}
@@ -0,0 +1,235 @@
+package qiniu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fastRetry 测试中使用的重试策略,没有 Retry-After 时几乎立即重试
+var fastRetry = RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+// newFlakyServer 前 failures 次请求返回 status 及 header,之后返回 200,返回收到的请求数
+func newFlakyServer(t *testing.T, failures int, status int, header http.Header, opts ...Option) (*QiniuClient, *atomic.Int32) {
+	t.Helper()
+	var count atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(count.Add(1)) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"code":0,"error":"failed"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	opts = append([]Option{WithBaseURL(srv.URL), WithRetryPolicy(fastRetry), WithRateLimit(1000, 1000)}, opts...)
+	return NewQiniuClient("ak", "sk", opts...), &count
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter func() string
+		min        time.Duration
+	}{
+		{"429 seconds", http.StatusTooManyRequests, func() string { return "1" }, time.Second},
+		{"573 http date", statusTooManyRequests, func() string {
+			return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+		}, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client, count := newFlakyServer(t, 1, tt.status, http.Header{"Retry-After": {tt.retryAfter()}})
+			// 被限流时即使是 POST 也会重试
+			start := time.Now()
+			if _, err := client.newReq(context.Background(), http.MethodPost, "/sslcert", nil); err != nil {
+				t.Fatal(err)
+			}
+			if n := count.Load(); n != 2 {
+				t.Fatalf("requests = %d, want 2", n)
+			}
+			// 退避时间只有 1ms,等待超过 1s 说明使用了 Retry-After
+			if elapsed := time.Since(start); elapsed < tt.min-100*time.Millisecond {
+				t.Fatalf("retried after %s, want at least %s", elapsed, tt.min)
+			}
+		})
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		requests int32
+		wantErr  bool
+	}{
+		{http.MethodGet, http.StatusInternalServerError, 2, false},
+		{http.MethodPut, http.StatusBadGateway, 2, false},
+		// 非幂等的请求在服务端错误时不重试,避免重复创建资源
+		{http.MethodPost, http.StatusInternalServerError, 1, true},
+		// 客户端错误不重试
+		{http.MethodGet, http.StatusBadRequest, 1, true},
+	}
+	for _, tt := range tests {
+		client, count := newFlakyServer(t, 1, tt.status, nil)
+		_, err := client.newReq(context.Background(), tt.method, "/domain/a.example.com", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %d: err = %v, want error %v", tt.method, tt.status, err, tt.wantErr)
+		}
+		if n := count.Load(); n != tt.requests {
+			t.Errorf("%s %d: requests = %d, want %d", tt.method, tt.status, n, tt.requests)
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	client, count := newFlakyServer(t, 10, http.StatusServiceUnavailable, nil)
+	_, err := client.newReq(context.Background(), http.MethodGet, "/domain", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want APIError 503", err)
+	}
+	if n := count.Load(); n != int32(fastRetry.MaxRetries)+1 {
+		t.Fatalf("requests = %d, want %d", n, fastRetry.MaxRetries+1)
+	}
+}
+
+func TestLimiterWaitCancelled(t *testing.T) {
+	// 每 10 秒一个令牌,第二个请求需要等待
+	client, count := newFlakyServer(t, 0, http.StatusOK, nil, WithRateLimit(0.1, 1))
+	if _, err := client.newReq(context.Background(), http.MethodGet, "/domain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.newReq(ctx, http.MethodGet, "/domain", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("cancelled wait returned after %s", elapsed)
+	}
+	if n := count.Load(); n != 1 {
+		t.Fatalf("requests = %d, want 1", n)
+	}
+	// 其他接口使用独立的令牌桶,不受影响
+	if _, err := client.newReq(context.Background(), http.MethodGet, "/sslcert", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		err    error
+		want   bool
+	}{
+		{"cancelled", http.MethodGet, context.Canceled, false},
+		{"deadline", http.MethodGet, context.DeadlineExceeded, false},
+		{"429 post", http.MethodPost, &APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"573 post", http.MethodPost, &APIError{StatusCode: statusTooManyRequests}, true},
+		{"500 get", http.MethodGet, &APIError{StatusCode: http.StatusInternalServerError}, true},
+		{"500 post", http.MethodPost, &APIError{StatusCode: http.StatusInternalServerError}, false},
+		{"404 get", http.MethodGet, &APIError{StatusCode: http.StatusNotFound}, false},
+		{"network get", http.MethodGet, errors.New("connection reset"), true},
+		{"network post", http.MethodPost, errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := shouldRetry(tt.method, tt.err); got != tt.want {
+			t.Errorf("%s: shouldRetry() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("parseRetryAfter(3) = %s", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %s, want about 1m", date, got)
+	}
+	for _, value := range []string{"", "0", "-1", "soon", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %s, want 0", value, got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, limit := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for range 100 {
+			if d := p.backoff(attempt); d <= 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want (0, %s]", attempt, d, limit)
+			}
+		}
+	}
+	// 位移溢出时使用上限
+	if d := p.backoff(100); d <= 0 || d > time.Second {
+		t.Fatalf("backoff(100) = %s", d)
+	}
+	if d := (RetryPolicy{}).backoff(0); d != 0 {
+		t.Fatalf("zero policy backoff = %s", d)
+	}
+}
+
+func TestEndpointKey(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodGet, "/domain", "GET /domain"},
+		{http.MethodGet, "/domain?marker=m1&limit=1000", "GET /domain"},
+		{http.MethodGet, "/domain/a.example.com", "GET /domain/{name}"},
+		{http.MethodGet, "/domain/b.example.com", "GET /domain/{name}"},
+		{http.MethodPut, "/domain/a.example.com/sslize", "PUT /domain/{name}/sslize"},
+		{http.MethodGet, "/sslcert/5f3a", "GET /sslcert/{name}"},
+		{http.MethodDelete, "/sslcert/5f3a", "DELETE /sslcert/{name}"},
+		{http.MethodPut, "/sslcert/5f3a/name", "PUT /sslcert/{name}/name"},
+	}
+	for _, tt := range tests {
+		if got := endpointKey(tt.method, tt.path); got != tt.want {
+			t.Errorf("endpointKey(%s, %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     rate.Limit
+		burst     int
+		endpoint  string
+		wantLimit rate.Limit
+		wantBurst int
+	}{
+		{"sslize stricter", 100, 100, "PUT /domain/{name}/sslize", rate.Every(time.Second), 1},
+		{"other endpoint", 100, 100, "GET /domain/{name}", 100, 100},
+		// 整体限流已经比覆盖值更严格时不会放宽
+		{"global stricter", 0.5, 1, "PUT /domain/{name}/sslize", 0.5, 1},
+	}
+	for _, tt := range tests {
+		l := newLimiter(tt.limit, tt.burst)
+		if err := l.wait(context.Background(), tt.endpoint); err != nil {
+			t.Fatal(err)
+		}
+		bucket := l.buckets[tt.endpoint]
+		if bucket.Limit() != tt.wantLimit || bucket.Burst() != tt.wantBurst {
+			t.Errorf("%s: limit/burst = %v/%d, want %v/%d", tt.name, bucket.Limit(), bucket.Burst(), tt.wantLimit, tt.wantBurst)
+		}
+	}
+}
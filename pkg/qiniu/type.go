@@ -2,30 +2,57 @@ package qiniu
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/qiniu/go-sdk/v7/auth"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"reflect"
+	"time"
 )
 
-// 获取域名列表请求,这里只用了一个limit字段,因为不怎么用得到其他的字段，具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#10
+// 获取域名列表请求,具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#10
 type GetDomainReq struct {
-	Limit int `json:"limit"`
+	Marker string `json:"marker"` // 上一页返回的游标,为空表示从头开始
+	Limit  int    `json:"limit"`
 }
 
 // 域名列表响应（对应 JSON 根对象）
 type GetDomainResp struct {
+	Marker  string   `json:"marker"` // 下一页的游标,为空表示没有更多数据
 	Domains []Domain `json:"domains"`
 }
 
+// 域名类型
+const (
+	DomainTypeNormal = "normal"   // 普通域名
+	DomainTypeWild   = "wildcard" // 泛域名
+	DomainTypePan    = "pan"      // 泛子域名
+	DomainTypeTest   = "test"     // 七牛云测试域名,无法绑定自有证书
+)
+
+// 域名运行状态
+const (
+	OperatingStateSuccess    = "success"
+	OperatingStateProcessing = "processing"
+	OperatingStateFailed     = "failed"
+	OperatingStateFrozen     = "frozen"
+	OperatingStateOfflined   = "offlined"
+)
+
 // Domain 结构体（对应 domains 数组中的每个对象）
 type Domain struct {
-	Name     string `json:"name"`     //域名
-	CreateAt string `json:"createAt"` // 域名创建时间，格式:RFC3339
+	Name               string `json:"name"`               //域名
+	Type               string `json:"type"`               // 域名类型,见 DomainType 常量
+	Protocol           string `json:"protocol"`           // 访问协议,http 或 https
+	OperatingState     string `json:"operatingState"`     // 运行状态,见 OperatingState 常量
+	OperatingStateDesc string `json:"operatingStateDesc"` // 运行状态描述
+	CreateAt           string `json:"createAt"`           // 域名创建时间，格式:RFC3339
+	ModifyAt           string `json:"modifyAt"`           // 域名修改时间，格式:RFC3339
 }
 
 type UPSSLCertReq struct {
@@ -36,7 +63,8 @@ type UPSSLCertReq struct {
 }
 
 type GetSSLCertListReq struct {
-	Limit int `json:"limit"`
+	Marker string `json:"marker"` // 上一页返回的游标,为空表示从头开始
+	Limit  int    `json:"limit"`
 }
 
 type GetSSLCertByIDResp struct {
@@ -67,15 +95,77 @@ type GetSSLCertByIDResp struct {
 	} `json:"cert"`
 }
 type GetSSLCertListResp struct {
-	Certs []Cert `json:"certs"`
+	Marker string `json:"marker"` // 下一页的游标,为空表示没有更多数据
+	Certs  []Cert `json:"certs"`
 }
 type GetSSLCertById struct {
 	Certs []Cert `json:"certs"`
 }
 type Cert struct {
-	CertId   string `json:"certid"`
-	Name     string `json:"name"`
-	NotAfter int64  `json:"not_after"`
+	CertId     string   `json:"certid"`
+	Name       string   `json:"name"`
+	CommonName string   `json:"common_name"`
+	DNSNames   []string `json:"dnsnames"`
+	NotBefore  int64    `json:"not_before"`
+	NotAfter   int64    `json:"not_after"`
+	CreateTime int64    `json:"create_time"`
+}
+
+// Expiry 证书过期时间
+func (c Cert) Expiry() time.Time {
+	return time.Unix(c.NotAfter, 0)
+}
+
+// SSLCert 证书详情,时间字段已经从秒级时间戳解析
+type SSLCert struct {
+	CertID     string
+	Name       string
+	CommonName string
+	DNSNames   []string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	CreateTime time.Time
+	Enable     bool
+}
+
+// SSLCertFilter 证书列表的过滤条件,字段为空时不过滤,均为精确匹配
+type SSLCertFilter struct {
+	Name       string
+	CommonName string
+}
+
+func (f SSLCertFilter) match(cert Cert) bool {
+	if f.Name != "" && cert.Name != f.Name {
+		return false
+	}
+	if f.CommonName != "" && cert.CommonName != f.CommonName {
+		return false
+	}
+	return true
+}
+
+// 修改证书名称请求
+type RenameSSLCertReq struct {
+	Name string `json:"name"`
+}
+
+// 域名详情,具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#11
+type DomainDetail struct {
+	Domain
+	HTTPS DomainHTTPS `json:"https"`
+}
+
+// DomainHTTPS 域名当前的 https 配置
+type DomainHTTPS struct {
+	CertID      string `json:"certId"`
+	ForceHttps  bool   `json:"forceHttps"`
+	Http2Enable bool   `json:"http2Enable"`
+}
+
+// HTTPSOptions 绑定证书时的 https 选项
+type HTTPSOptions struct {
+	ForceHttps  bool // 强制 http 跳转到 https
+	Http2Enable bool // 开启 http2
 }
 
 type ForceHTTPSReq struct {
@@ -90,9 +180,9 @@ type UPSSLCertResp struct {
 
 //内部通用函数
 
-// 发送 HTTP 请求，自动处理参数方式
-func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
-	var body io.Reader
+// 发送 HTTP 请求，自动处理参数方式,并按照限流和重试策略发送
+func (c *QiniuClient) newReq(ctx context.Context, method, path string, data any) ([]byte, error) {
+	var jsonData []byte
 	urlParams := url.Values{}
 
 	// 解析 struct 并根据 method 选择传参方式
@@ -109,18 +199,61 @@ func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
 			}
 			path = fmt.Sprintf("%s?%s", path, urlParams.Encode())
 		} else {
-			jsonData, err := json.Marshal(data)
+			jsonData, err = json.Marshal(data)
 			if err != nil {
 				return nil, err
 			}
-			body = bytes.NewBuffer(jsonData)
 		}
 	}
 
+	endpoint := endpointKey(method, path)
+	for attempt := 0; ; attempt++ {
+		// 每个接口独立限流
+		if err := c.limiter.wait(ctx, endpoint); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		result, status, err := c.do(ctx, method, path, jsonData)
+		if c.observer != nil {
+			c.observer(endpoint, time.Since(start), status, err)
+		}
+		if err == nil {
+			return result, nil
+		}
+		if attempt >= c.retryPolicy.MaxRetries || !shouldRetry(method, err) {
+			return nil, err
+		}
+
+		// 优先使用七牛云返回的 Retry-After,否则指数退避
+		delay := c.retryPolicy.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+		log.Printf("%s 请求失败,%s 后进行第 %d 次重试:%v", endpoint, delay, attempt+1, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// do 发送单次请求并返回 HTTP 状态码,非 2xx 的响应转化为 APIError
+func (c *QiniuClient) do(ctx context.Context, method, path string, jsonData []byte) ([]byte, int, error) {
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewReader(jsonData)
+	}
+
 	// 构造请求
-	req, err := http.NewRequest(method, QiniuBaseUrl+path, body)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, body)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	//选择请求头
@@ -133,22 +266,28 @@ func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
 
 	// 添加 Token 认证
 	if err := c.qiniuClient.AddToken(auth.TokenQBox, req); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	//发送请求
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer resp.Body.Close()
 
 	//处理结果并转化为[]byte
 	result, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, resp.StatusCode, err
 	}
 
-	return result, nil
+	// 非 2xx 的响应统一转化为 APIError
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, resp.StatusCode, newAPIError(resp, result)
+	}
+
+	return result, resp.StatusCode, nil
 }
 
 func (c *QiniuClient) structToMap(data any) (map[string]string, error) {
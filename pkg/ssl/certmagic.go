@@ -2,22 +2,23 @@ package ssl
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 
 	"github.com/caddyserver/certmagic"
 )
 
-// NewCertMagicClient 生成 CertMagicClient，用户可以自定义传入 libdns 兼容的 Provider
-func NewCertMagicClient(email, path string, provider Provider) (*CertMagicClient, error) {
+// NewCertMagicClient 生成 CertMagicClient，用户可以自定义传入 libdns 兼容的 Provider,
+// 多个 DNS 平台时传入 DNSRouter
+func NewCertMagicClient(email, path string, dnsProvider certmagic.DNSProvider) (*CertMagicClient, error) {
 	if email == "" {
 		email = "admin@yourdomain.com"
 	}
 
-	//根据配置去获取dns配置
-	dnsProvider, err := NewDNSProvider(provider)
-	if err != nil {
-		return nil, err
-	}
-
 	// 配置 CertMagic
 	certmagic.DefaultACME.Email = email
 	certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
@@ -33,31 +34,51 @@ func NewCertMagicClient(email, path string, provider Provider) (*CertMagicClient
 	cm := certmagic.NewDefault()
 	cm.Storage = &certmagic.FileStorage{Path: path}
 
-	return &CertMagicClient{cm: cm}, nil
+	// certmagic 的托管证书只支持单个名称,多 SAN 证书直接使用 ACME Issuer 签发
+	issuer := certmagic.NewACMEIssuer(cm, certmagic.DefaultACME)
+
+	return &CertMagicClient{cm: cm, issuer: issuer}, nil
 }
 
 type CertMagicClient struct {
-	cm *certmagic.Config
+	cm     *certmagic.Config
+	issuer *certmagic.ACMEIssuer
 }
 
-// 强制获取证书（不走缓存）
-func (c *CertMagicClient) ObtainCert(ctx context.Context, domain string) (string, string, error) {
+// ObtainCert 申请一张包含全部 names 的证书（不走缓存）,names[0] 作为 CommonName
+func (c *CertMagicClient) ObtainCert(ctx context.Context, names ...string) (string, string, error) {
+	if len(names) == 0 {
+		return "", "", fmt.Errorf("至少需要一个域名")
+	}
 
-	err := c.cm.RenewCertSync(ctx, domain, false) // false 表示不进入预留的过期检查逻辑
+	// 每次申请都生成新的私钥
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("生成私钥失败: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return "", "", fmt.Errorf("生成 CSR 失败: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return "", "", fmt.Errorf("解析 CSR 失败: %w", err)
 	}
 
-	// 获取最新申请到的证书（此时缓存已更新）
-	cert, err := c.cm.CacheManagedCertificate(ctx, domain)
+	issued, err := c.issuer.Issue(ctx, csr)
 	if err != nil {
 		return "", "", err
 	}
 
-	certPEM, keyPEM, err := c.convertCertToPEM(cert.Certificate)
+	keyPEM, err := encodeKeyPEM(key)
 	if err != nil {
 		return "", "", err
 	}
 
-	return certPEM, keyPEM, nil
+	// 签发结果已经是包含证书链的 PEM
+	return string(issued.Certificate), keyPEM, nil
 }
@@ -2,6 +2,8 @@ package ssl
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/caddyserver/certmagic"
 	"github.com/libdns/alidns"
 	"github.com/libdns/cloudflare"
@@ -14,8 +16,15 @@ const (
 	CloudFlare = "cloudflare"
 )
 
-// NewDNSProvider 虽然这里提供了三种但是实际上只用过aliyun的
+// Platforms 支持的 DNS 平台
+var Platforms = []string{Aliyun, Tencent, CloudFlare}
+
+// NewDNSProvider 根据平台生成对应的 libdns Provider
 func NewDNSProvider(p Provider) (certmagic.DNSProvider, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch p.Platform {
 	case Aliyun:
 		return &alidns.Provider{
@@ -33,7 +42,7 @@ func NewDNSProvider(p Provider) (certmagic.DNSProvider, error) {
 		}, nil
 	default:
 		//显示返回不支持的平台
-		return nil, fmt.Errorf("Unsupported platform")
+		return nil, fmt.Errorf("unsupported dns platform %q", p.Platform)
 	}
 }
 
@@ -44,6 +53,25 @@ type Provider struct {
 	Token           string `json:"token"` //对于某些只需要单个token的服务(可以考虑复用AccessKeySercet)
 }
 
+// Validate 检查平台是否受支持以及所需的凭证是否完整
+func (p Provider) Validate() error {
+	switch p.Platform {
+	case Aliyun, Tencent:
+		if p.AccessKeyID == "" || p.AccessKeySecret == "" {
+			return fmt.Errorf("dns platform %q requires accessKeyID and accessKeySecret", p.Platform)
+		}
+	case CloudFlare:
+		if p.Token == "" {
+			return fmt.Errorf("dns platform %q requires token", p.Platform)
+		}
+	case "":
+		return fmt.Errorf("dns platform is empty, supported platforms: %s", strings.Join(Platforms, ", "))
+	default:
+		return fmt.Errorf("unsupported dns platform %q, supported platforms: %s", p.Platform, strings.Join(Platforms, ", "))
+	}
+	return nil
+}
+
 func NewProvider(platform, accessKeyID, accessKeySecret, token string) Provider {
 	return Provider{
 		AccessKeyID:     accessKeyID,
@@ -0,0 +1,117 @@
+package ssl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+)
+
+// Route 将某个父域名或域名后缀交给指定的 DNS 平台处理
+type Route struct {
+	Suffix   string   `json:"suffix"`
+	Provider Provider `json:"provider"`
+}
+
+type route struct {
+	suffix   string
+	platform string
+	provider certmagic.DNSProvider
+}
+
+// DNSRouter 按照 zone 的后缀将 DNS 记录的增删分发给不同的 DNS 平台,
+// 本身实现了 certmagic.DNSProvider,可以直接作为 DNS01Solver 使用
+type DNSRouter struct {
+	routes   []route // 按后缀长度倒序排列,保证最长匹配
+	fallback *route  // 没有匹配到任何后缀时使用,可以为空
+}
+
+// NewDNSRouter 生成 DNSRouter,fallback 的 Platform 为空时表示没有默认平台
+func NewDNSRouter(fallback Provider, routes []Route) (*DNSRouter, error) {
+	r := &DNSRouter{}
+
+	if fallback.Platform != "" {
+		dnsProvider, err := NewDNSProvider(fallback)
+		if err != nil {
+			return nil, err
+		}
+		r.fallback = &route{platform: fallback.Platform, provider: dnsProvider}
+	}
+
+	seen := make(map[string]struct{})
+	for _, rt := range routes {
+		suffix := normalizeZone(rt.Suffix)
+		if suffix == "" {
+			return nil, fmt.Errorf("dns route suffix is empty")
+		}
+		if _, ok := seen[suffix]; ok {
+			return nil, fmt.Errorf("dns route suffix %q is duplicated", suffix)
+		}
+		seen[suffix] = struct{}{}
+
+		dnsProvider, err := NewDNSProvider(rt.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("dns route %q: %w", suffix, err)
+		}
+		r.routes = append(r.routes, route{suffix: suffix, platform: rt.Provider.Platform, provider: dnsProvider})
+	}
+
+	if r.fallback == nil && len(r.routes) == 0 {
+		return nil, fmt.Errorf("no dns provider configured")
+	}
+
+	sort.Slice(r.routes, func(i, j int) bool {
+		return len(r.routes[i].suffix) > len(r.routes[j].suffix)
+	})
+	return r, nil
+}
+
+// Platform 返回负责该域名的 DNS 平台,用于在申请证书之前检查配置
+func (r *DNSRouter) Platform(domain string) (string, error) {
+	rt, err := r.match(domain)
+	if err != nil {
+		return "", err
+	}
+	return rt.platform, nil
+}
+
+func (r *DNSRouter) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rt, err := r.match(zone)
+	if err != nil {
+		return nil, err
+	}
+	return rt.provider.AppendRecords(ctx, zone, recs)
+}
+
+func (r *DNSRouter) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rt, err := r.match(zone)
+	if err != nil {
+		return nil, err
+	}
+	return rt.provider.DeleteRecords(ctx, zone, recs)
+}
+
+// match 查找后缀最长的匹配项,找不到时使用默认平台
+func (r *DNSRouter) match(zone string) (*route, error) {
+	zone = normalizeZone(zone)
+	for i := range r.routes {
+		suffix := r.routes[i].suffix
+		if zone == suffix || strings.HasSuffix(zone, "."+suffix) {
+			return &r.routes[i], nil
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback, nil
+	}
+	return nil, fmt.Errorf("no dns provider configured for %q", zone)
+}
+
+// normalizeZone 去掉泛域名前缀和末尾的点并转为小写
+func normalizeZone(zone string) string {
+	zone = strings.TrimPrefix(zone, "*.")
+	zone = strings.Trim(zone, ".")
+	return strings.ToLower(zone)
+}
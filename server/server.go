@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/metrics"
+)
+
+// Server 管理接口,用于查看证书、手动触发续期和同步,以及提供 Prometheus 指标
+type Server struct {
+	srv      *http.Server
+	qiniuSSL *cron.QiniuSSL
+	token    string
+
+	// 手动触发的任务使用的 context,随服务退出而取消
+	ctx context.Context
+}
+
+// NewServer 创建管理接口,未配置 server.addr 时返回 nil 表示不启用
+func NewServer(conf *config.Conf, qiniuSSL *cron.QiniuSSL) (*Server, error) {
+	if conf.Server.Addr == "" {
+		return nil, nil
+	}
+	if conf.Server.Token == "" {
+		return nil, fmt.Errorf("启用管理接口时必须配置 server.token")
+	}
+
+	s := &Server{
+		qiniuSSL: qiniuSSL,
+		token:    conf.Server.Token,
+		ctx:      context.Background(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/certs", s.listCerts)
+	mux.HandleFunc("POST /api/certs/{domain}/renew", s.renew)
+	mux.HandleFunc("POST /api/sync", s.sync)
+	mux.HandleFunc("GET /api/results", s.results)
+	mux.HandleFunc("GET /api/plan", s.plan)
+	mux.HandleFunc("GET /api/runs", s.runs)
+	mux.HandleFunc("GET /api/audit", s.auditLogs)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	s.srv = &http.Server{
+		Addr:              conf.Server.Addr,
+		Handler:           s.auth(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Run 启动管理接口,ctx 取消后优雅关闭
+func (s *Server) Run(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.ctx = ctx
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("管理接口监听于 %s", s.srv.Addr)
+		errCh <- s.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(shutdownCtx)
+}
+
+// auth 校验 Authorization: Bearer <token>
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// certView 证书的展示信息,不包含私钥
+type certView struct {
+	DomainName string    `json:"domainName"`
+	CertID     string    `json:"certId"`
+	SANs       []string  `json:"sans"`
+	NotAfter   time.Time `json:"notAfter"`
+	DaysLeft   int       `json:"daysLeft"`
+	Domains    []string  `json:"domains"`
+}
+
+func (s *Server) listCerts(w http.ResponseWriter, r *http.Request) {
+	ssls, err := s.qiniuSSL.ListSSL()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	views := make([]certView, 0, len(ssls))
+	for _, ssl := range ssls {
+		view := certView{
+			DomainName: ssl.DomainName,
+			CertID:     ssl.CertID,
+			SANs:       ssl.SANs,
+			NotAfter:   ssl.NotAfter,
+			DaysLeft:   int(time.Until(ssl.NotAfter).Hours() / 24),
+			Domains:    make([]string, 0, len(ssl.Domains)),
+		}
+		for _, domain := range ssl.Domains {
+			view.Domains = append(view.Domains, domain.Name)
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// renew 在后台强制续期某个可注册域名的证书,结果通过 /api/results 查看
+func (s *Server) renew(w http.ResponseWriter, r *http.Request) {
+	s.accepted(w, s.qiniuSSL.RenewAsync(s.ctx, r.PathValue("domain")))
+}
+
+// sync 在后台执行一次完整的同步,结果通过 /api/results 查看
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	s.accepted(w, s.qiniuSSL.SyncAsync(s.ctx))
+}
+
+func (s *Server) results(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.qiniuSSL.LastResult())
+}
+
+// plan 返回下一次同步将要执行的操作,不产生任何修改
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	plans, err := s.qiniuSSL.Plan(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plans)
+}
+
+// runs 返回最近的同步记录,?limit= 默认 20
+func (s *Server) runs(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	runs, err := s.qiniuSSL.Runs(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// auditLogs 查询审计日志,支持 domain、certId、action、runId、since(RFC3339) 和 limit 参数
+func (s *Server) auditLogs(w http.ResponseWriter, r *http.Request) {
+	query := dao.AuditQuery{
+		Domain: r.URL.Query().Get("domain"),
+		CertID: r.URL.Query().Get("certId"),
+		Action: r.URL.Query().Get("action"),
+	}
+	runID, err := queryInt(r, "runId", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	query.RunID = uint(runID)
+	if query.Limit, err = queryInt(r, "limit", 0); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("since 格式错误:%w", err))
+			return
+		}
+	}
+
+	logs, err := s.qiniuSSL.AuditLogs(query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+// queryInt 读取非负整数查询参数,为空时返回 def
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s 必须是非负整数", key)
+	}
+	return n, nil
+}
+
+// accepted 返回后台任务是否已经开始,已有任务在执行时返回 409
+func (s *Server) accepted(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+	case errors.Is(err, cron.ErrBusy):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
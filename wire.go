@@ -5,20 +5,41 @@ package main
 
 import (
 	"github.com/google/wire"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
 	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/server"
+)
+
+// coreSet 常驻服务和一次性命令共用的依赖
+var coreSet = wire.NewSet(
+	config.Load,
+	cron.NewQiniuClient,
+	cron.NewEmailClient,
+	cron.NewSSLDao,
+	cron.NewQiniuSSL,
 )
 
 // wireSet 定义所有依赖
 var wireSet = wire.NewSet(
-	cron.NewQiniuSSL,
+	coreSet,
+	server.NewServer,
 	cron.NewCorn,
 	NewApp,
 )
 
-func InitApp() (*App, error) {
+func InitApp(flags config.Flags) (*App, error) {
 	wire.Build(
 		wireSet,
 	)
 	return &App{}, nil
 
 }
+
+// InitCLI 一次性命令使用的依赖,与 InitApp 共用同一套构造函数
+func InitCLI(flags config.Flags) (*CLI, error) {
+	wire.Build(
+		coreSet,
+		NewCLI,
+	)
+	return &CLI{}, nil
+}
@@ -8,13 +8,25 @@ package main
 
 import (
 	"github.com/google/wire"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
 	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/server"
 )
 
 // Injectors from wire.go:
 
-func InitApp() (*App, error) {
-	qiniuSSL, err := cron.NewQiniuSSL()
+func InitApp(flags config.Flags) (*App, error) {
+	conf, err := config.Load(flags)
+	if err != nil {
+		return nil, err
+	}
+	qiniuClient := cron.NewQiniuClient(conf)
+	store, err := cron.NewSSLDao(conf)
+	if err != nil {
+		return nil, err
+	}
+	emailClient := cron.NewEmailClient(conf)
+	qiniuSSL, err := cron.NewQiniuSSL(conf, qiniuClient, store, emailClient)
 	if err != nil {
 		return nil, err
 	}
@@ -22,14 +34,43 @@ func InitApp() (*App, error) {
 	if err != nil {
 		return nil, err
 	}
-	app, err := NewApp(corn)
+	serverServer, err := server.NewServer(conf, qiniuSSL)
+	if err != nil {
+		return nil, err
+	}
+	app, err := NewApp(corn, serverServer)
 	if err != nil {
 		return nil, err
 	}
 	return app, nil
 }
 
+// InitCLI 一次性命令使用的依赖,与 InitApp 共用同一套构造函数
+func InitCLI(flags config.Flags) (*CLI, error) {
+	conf, err := config.Load(flags)
+	if err != nil {
+		return nil, err
+	}
+	qiniuClient := cron.NewQiniuClient(conf)
+	store, err := cron.NewSSLDao(conf)
+	if err != nil {
+		return nil, err
+	}
+	emailClient := cron.NewEmailClient(conf)
+	qiniuSSL, err := cron.NewQiniuSSL(conf, qiniuClient, store, emailClient)
+	if err != nil {
+		return nil, err
+	}
+	cli := NewCLI(conf, qiniuSSL, store, qiniuClient)
+	return cli, nil
+}
+
 // wire.go:
 
+// coreSet 常驻服务和一次性命令共用的依赖
+var coreSet = wire.NewSet(config.Load, cron.NewQiniuClient, cron.NewEmailClient, cron.NewSSLDao, cron.NewQiniuSSL)
+
 // wireSet 定义所有依赖
-var wireSet = wire.NewSet(cron.NewQiniuSSL, cron.NewCorn, NewApp)
+var wireSet = wire.NewSet(
+	coreSet, server.NewServer, cron.NewCorn, NewApp,
+)